@@ -0,0 +1,29 @@
+// Package autherr holds the errors of user authentication, shared by the server
+// and the client. The server writes their messages, the client maps them back.
+package autherr
+
+import "errors"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserAlreadyExists  = errors.New("user already exists")
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrEmptyPassword      = errors.New("empty password")
+	ErrAccountLocked      = errors.New("account locked")
+	// ErrInvalidVerification is returned for an unknown, expired or already used verification token
+	ErrInvalidVerification = errors.New("invalid verification token")
+	// ErrWeakPassword is returned for a password breaking the password policy
+	ErrWeakPassword = errors.New("password does not meet the policy")
+	// Signing in is refused for a while after too many failures, for the account or the client IP
+	ErrAccountTemporarilyLocked = errors.New("account temporarily locked")
+	ErrTooManyAttempts          = errors.New("too many failed attempts")
+)
+
+// Rules of the password policy, reported with ErrWeakPassword
+const (
+	ViolationTooShort      = "too_short"
+	ViolationTooLong       = "too_long"
+	ViolationContainsEmail = "contains_email"
+	ViolationBreached      = "breached"
+)
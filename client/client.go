@@ -0,0 +1,377 @@
+// Package client is a typed Go client for the auth-session HTTP API. It covers
+// every endpoint but /forward-auth, which is meant for reverse proxies.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aloysb/auth-session/autherr"
+	"github.com/aloysb/auth-session/middleware"
+	"github.com/aloysb/auth-session/session"
+)
+
+// Timeout of the HTTP client used when none is provided
+const DefaultTimeout = 10 * time.Second
+
+// Errors returned by the server, to compare with errors.Is
+var (
+	ErrInvalidCredentials     = autherr.ErrInvalidCredentials
+	ErrUserNotFound           = autherr.ErrUserNotFound
+	ErrUserAlreadyExists      = autherr.ErrUserAlreadyExists
+	ErrInvalidEmail           = autherr.ErrInvalidEmail
+	ErrEmptyPassword          = autherr.ErrEmptyPassword
+	ErrAccountLocked          = autherr.ErrAccountLocked
+	ErrInvalidSession         = session.ErrInvalidSession
+	ErrExpiredSession         = session.ErrExpiredSession
+	ErrNoToken                = middleware.ErrNoToken
+	ErrMalformedAuthorization = middleware.ErrMalformedAuthorization
+	ErrInvalidRefreshToken    = session.ErrInvalidRefreshToken
+	ErrRefreshTokenReused     = session.ErrRefreshTokenReused
+	ErrInvalidVerification    = autherr.ErrInvalidVerification
+	ErrWeakPassword           = autherr.ErrWeakPassword
+	// Signing in is refused for Error.RetryAfter after too many failures
+	ErrAccountTemporarilyLocked = autherr.ErrAccountTemporarilyLocked
+	ErrTooManyAttempts          = autherr.ErrTooManyAttempts
+)
+
+// Rules of the password policy, listed in Error.Violations with ErrWeakPassword
+const (
+	ViolationTooShort      = autherr.ViolationTooShort
+	ViolationTooLong       = autherr.ViolationTooLong
+	ViolationContainsEmail = autherr.ViolationContainsEmail
+	ViolationBreached      = autherr.ViolationBreached
+)
+
+// ErrVerificationPending is returned by SignUp against a hardened server: the user
+// signs in once they verified their email with VerifyEmail
+var ErrVerificationPending = errors.New("email verification pending")
+
+// knownErrors maps the messages written by the server back to its sentinel errors
+var knownErrors = []error{
+	ErrInvalidCredentials,
+	ErrUserNotFound,
+	ErrUserAlreadyExists,
+	ErrInvalidEmail,
+	ErrEmptyPassword,
+	ErrAccountLocked,
+	ErrInvalidSession,
+	ErrExpiredSession,
+	ErrNoToken,
+	ErrMalformedAuthorization,
+	ErrInvalidRefreshToken,
+	ErrRefreshTokenReused,
+	ErrInvalidVerification,
+	ErrWeakPassword,
+	ErrAccountTemporarilyLocked,
+	ErrTooManyAttempts,
+}
+
+// Transport is how the session token travels between the client and the server
+type Transport int
+
+const (
+	// CookieTransport sends the token in the session cookie, like a browser
+	CookieTransport Transport = iota
+	// BearerTransport sends the token in an Authorization: Bearer header
+	BearerTransport
+)
+
+// Options customises the client
+type Options struct {
+	// HTTPClient sends the requests, defaults to a client with DefaultTimeout
+	HTTPClient *http.Client
+	// Transport of the session token, defaults to CookieTransport
+	Transport Transport
+	// CookieName is the name of the session cookie, defaults to session.COOKIE_NAME.
+	// Include the __Host- prefix when the server adds it.
+	CookieName string
+}
+
+// Error is a non-2xx response. It unwraps to the matching sentinel error when the server sent one.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Violations lists the rules of the password policy a new password breaks
+	Violations []string
+	// RetryAfter is how long to wait before signing in again after too many failures
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("auth-session: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Login is the session created by Login, SignUp or Refresh, with the token to authenticate
+// the next requests. RefreshToken is only set when the server issues refresh tokens.
+type Login struct {
+	Session      session.Session
+	Token        session.Token
+	RefreshToken session.Token
+}
+
+// Sessions lists the active sessions of a user
+type Sessions struct {
+	CurrentSessionId session.SessionID `json:"current_session_id"`
+	Sessions         []session.Session `json:"sessions"`
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	transport  Transport
+	cookieName string
+}
+
+// New creates a client for the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, options Options) *Client {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	if options.CookieName == "" {
+		options.CookieName = session.COOKIE_NAME
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: options.HTTPClient,
+		transport:  options.Transport,
+		cookieName: options.CookieName,
+	}
+}
+
+// Login signs the user in and creates a session
+func (c *Client) Login(ctx context.Context, email, password string) (*Login, error) {
+	return c.login(ctx, "/login", email, password)
+}
+
+// SignUp creates the user and signs them in. A hardened server does not sign them in,
+// it returns ErrVerificationPending whether or not the email already had an account.
+func (c *Client) SignUp(ctx context.Context, email, password string) (*Login, error) {
+	return c.login(ctx, "/signup", email, password)
+}
+
+func (c *Client) login(ctx context.Context, path, email, password string) (*Login, error) {
+	form := url.Values{"email": {email}, "password": {password}}
+	if c.transport == BearerTransport {
+		form.Set("transport", "bearer")
+	}
+
+	return c.session(ctx, path, form, nil)
+}
+
+// Refresh exchanges a refresh token for a new session and refresh token. The refresh
+// token cannot be used again: a second exchange fails with ErrRefreshTokenReused and
+// signs the login out.
+func (c *Client) Refresh(ctx context.Context, refreshToken session.Token) (*Login, error) {
+	if c.transport == BearerTransport {
+		return c.session(ctx, "/refresh", url.Values{"refresh_token": {string(refreshToken)}}, nil)
+	}
+	return c.session(ctx, "/refresh", url.Values{}, &http.Cookie{Name: c.refreshCookieName(), Value: string(refreshToken)})
+}
+
+// session posts the form and reads the session of the response, along with its tokens
+func (c *Client) session(ctx context.Context, path string, form url.Values, cookie *http.Cookie) (*Login, error) {
+	resp, err := c.send(ctx, http.MethodPost, path, "", form, cookie)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, ErrVerificationPending
+	}
+
+	var body struct {
+		Session      session.Session `json:"session"`
+		Token        session.Token   `json:"token"`
+		RefreshToken session.Token   `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("could not decode session: %w", err)
+	}
+
+	// Cookie clients get the tokens in the cookies only
+	if body.Token == "" {
+		for _, cookie := range resp.Cookies() {
+			switch cookie.Name {
+			case c.cookieName:
+				body.Token = session.Token(cookie.Value)
+			case c.refreshCookieName():
+				body.RefreshToken = session.Token(cookie.Value)
+			}
+		}
+	}
+	if body.Token == "" {
+		return nil, errors.New("the server did not return a session token")
+	}
+
+	return &Login{Session: body.Session, Token: body.Token, RefreshToken: body.RefreshToken}, nil
+}
+
+// refreshCookieName is the name of the cookie the server puts refresh tokens in
+func (c *Client) refreshCookieName() string {
+	return c.cookieName + "_refresh"
+}
+
+// VerifyEmail confirms the email of a hardened sign up with the token sent to it
+func (c *Client) VerifyEmail(ctx context.Context, token string) error {
+	return c.doAndClose(ctx, http.MethodPost, "/verify", "", url.Values{"token": {token}})
+}
+
+// Authenticate validates the session and returns the id of its user
+func (c *Client) Authenticate(ctx context.Context, token session.Token) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/authenticate", token, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	userId, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read response: %w", err)
+	}
+	return string(userId), nil
+}
+
+// Logout invalidates the session
+func (c *Client) Logout(ctx context.Context, token session.Token) error {
+	return c.doAndClose(ctx, http.MethodPost, "/logout", token, nil)
+}
+
+// ChangePassword replaces the password of the session's user, revoking their other sessions
+func (c *Client) ChangePassword(ctx context.Context, token session.Token, currentPassword, newPassword string) error {
+	form := url.Values{"current_password": {currentPassword}, "new_password": {newPassword}}
+	return c.doAndClose(ctx, http.MethodPost, "/password", token, form)
+}
+
+// ListSessions returns the active sessions of the session's user, most recently used first
+func (c *Client) ListSessions(ctx context.Context, token session.Token) (*Sessions, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/sessions", token, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var sessions Sessions
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("could not decode sessions: %w", err)
+	}
+	return &sessions, nil
+}
+
+// RevokeSession revokes one of the user's sessions by its id
+func (c *Client) RevokeSession(ctx context.Context, token session.Token, sessionId session.SessionID) error {
+	return c.doAndClose(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(string(sessionId)), token, nil)
+}
+
+// RevokeOtherSessions revokes every session of the user but the current one, and returns how many were revoked
+func (c *Client) RevokeOtherSessions(ctx context.Context, token session.Token) (int, error) {
+	resp, err := c.do(ctx, http.MethodDelete, "/sessions", token, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Revoked int `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("could not decode response: %w", err)
+	}
+	return body.Revoked, nil
+}
+
+func (c *Client) doAndClose(ctx context.Context, method, path string, token session.Token, form url.Values) error {
+	resp, err := c.do(ctx, method, path, token, form)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends the request with the token, if any, and turns non-2xx responses into an *Error
+func (c *Client) do(ctx context.Context, method, path string, token session.Token, form url.Values) (*http.Response, error) {
+	return c.send(ctx, method, path, token, form, nil)
+}
+
+// send is do with an extra cookie, if any
+func (c *Client) send(ctx context.Context, method, path string, token session.Token, form url.Values, cookie *http.Cookie) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if token != "" {
+		switch c.transport {
+		case BearerTransport:
+			req.Header.Set("Authorization", "Bearer "+string(token))
+		default:
+			req.AddCookie(&http.Cookie{Name: c.cookieName, Value: string(token)})
+		}
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError reads the error message of the response. The server writes the
+// message of its sentinel errors as is, so they can be matched back.
+func responseError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	// Password policy errors list their violations
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Error      string   `json:"error"`
+			Violations []string `json:"violations"`
+		}
+		if json.Unmarshal(message, &body) == nil {
+			e.Message, e.Violations = body.Error, body.Violations
+		}
+	}
+	for _, known := range knownErrors {
+		if strings.EqualFold(e.Message, known.Error()) {
+			e.err = known
+			break
+		}
+	}
+	// Revoking a session that is not one of the user's
+	if e.err == nil && resp.StatusCode == http.StatusNotFound && e.Message == "session not found" {
+		e.err = ErrInvalidSession
+	}
+	return e
+}
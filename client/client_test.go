@@ -0,0 +1,323 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/internal/server"
+	"github.com/aloysb/auth-session/session"
+)
+
+// setupServer runs the real server, backed by memory stores
+func setupServer(t *testing.T, sessionConfig session.Config) (*httptest.Server, *auth.BasicAuthService) {
+	sessionStore := session.NewMemoryStore()
+	authService := auth.New(auth.NewMemoryStore(sessionStore), auth.DefaultConfig())
+	serverConfig := server.DefaultConfig()
+	serverConfig.RefreshTokens = sessionConfig.RefreshTokenLifetime > 0
+	srv := server.New(session.New(sessionStore, sessionConfig), authService, serverConfig)
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts, authService
+}
+
+func TestClient_Flow(t *testing.T) {
+	for name, transport := range map[string]Transport{"cookie": CookieTransport, "bearer": BearerTransport} {
+		t.Run(name, func(t *testing.T) {
+			ts, _ := setupServer(t, session.DefaultConfig())
+			c := New(ts.URL, Options{Transport: transport})
+			ctx := context.Background()
+
+			first, err := c.SignUp(ctx, "user@example.com", "password")
+			if err != nil {
+				t.Fatalf("SignUp: %v", err)
+			}
+			if first.Session.UserId != "user@example.com" || first.Token == "" {
+				t.Fatalf("unexpected login: %+v", first)
+			}
+
+			userId, err := c.Authenticate(ctx, first.Token)
+			if err != nil || userId != "user@example.com" {
+				t.Fatalf("Authenticate: got (%q, %v)", userId, err)
+			}
+
+			second, err := c.Login(ctx, "user@example.com", "password")
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+
+			sessions, err := c.ListSessions(ctx, second.Token)
+			if err != nil {
+				t.Fatalf("ListSessions: %v", err)
+			}
+			if sessions.CurrentSessionId != second.Session.Id || len(sessions.Sessions) != 2 {
+				t.Fatalf("unexpected sessions: %+v", sessions)
+			}
+
+			revoked, err := c.RevokeOtherSessions(ctx, second.Token)
+			if err != nil || revoked != 1 {
+				t.Fatalf("RevokeOtherSessions: got (%d, %v)", revoked, err)
+			}
+			if _, err := c.Authenticate(ctx, first.Token); !errors.Is(err, ErrInvalidSession) {
+				t.Fatalf("revoked session: got %v, want %v", err, ErrInvalidSession)
+			}
+
+			if err := c.ChangePassword(ctx, second.Token, "password", "new password"); err != nil {
+				t.Fatalf("ChangePassword: %v", err)
+			}
+			if _, err := c.Login(ctx, "user@example.com", "password"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("old password: got %v, want %v", err, ErrInvalidCredentials)
+			}
+
+			if err := c.Logout(ctx, second.Token); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			if _, err := c.Authenticate(ctx, second.Token); !errors.Is(err, ErrInvalidSession) {
+				t.Fatalf("logged out session: got %v, want %v", err, ErrInvalidSession)
+			}
+		})
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ts, authService := setupServer(t, session.DefaultConfig())
+	c := New(ts.URL, Options{Transport: BearerTransport})
+	ctx := context.Background()
+
+	login, err := c.SignUp(ctx, "user@example.com", "password")
+	if err != nil {
+		t.Fatalf("SignUp: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{
+			name: "wrong password",
+			call: func() error { _, err := c.Login(ctx, "user@example.com", "wrong"); return err },
+			want: ErrInvalidCredentials,
+		},
+		{
+			name: "unknown user",
+			call: func() error { _, err := c.Login(ctx, "unknown@example.com", "password"); return err },
+			want: ErrUserNotFound,
+		},
+		{
+			name: "existing user",
+			call: func() error { _, err := c.SignUp(ctx, "user@example.com", "password"); return err },
+			want: ErrUserAlreadyExists,
+		},
+		{
+			name: "invalid email",
+			call: func() error { _, err := c.SignUp(ctx, "not an email", "password"); return err },
+			want: ErrInvalidEmail,
+		},
+		{
+			name: "weak password",
+			call: func() error {
+				_, err := c.SignUp(ctx, "other@example.com", "short")
+				var apiErr *Error
+				if errors.As(err, &apiErr) && (len(apiErr.Violations) != 1 || apiErr.Violations[0] != ViolationTooShort) {
+					t.Errorf("expected the too_short violation, got %v", apiErr.Violations)
+				}
+				return err
+			},
+			want: ErrWeakPassword,
+		},
+		{
+			name: "no token",
+			call: func() error { _, err := c.Authenticate(ctx, ""); return err },
+			want: ErrNoToken,
+		},
+		{
+			name: "unknown session",
+			call: func() error { return c.RevokeSession(ctx, login.Token, "unknown") },
+			want: ErrInvalidSession,
+		},
+		{
+			name: "locked account",
+			call: func() error {
+				if err := authService.LockUser("user@example.com"); err != nil {
+					t.Fatalf("LockUser: %v", err)
+				}
+				_, err := c.Login(ctx, "user@example.com", "password")
+				return err
+			},
+			want: ErrAccountLocked,
+		},
+	}
+
+	for _, tt := range tests {
+		err := tt.call()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode < 400 {
+			t.Errorf("%s: expected an *Error with the response status, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestClient_ExpiredSession(t *testing.T) {
+	ts, _ := setupServer(t, session.Config{IdleTimeout: 10 * time.Millisecond})
+	c := New(ts.URL, Options{})
+	ctx := context.Background()
+
+	login, err := c.SignUp(ctx, "user@example.com", "password")
+	if err != nil {
+		t.Fatalf("SignUp: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.Authenticate(ctx, login.Token); !errors.Is(err, ErrExpiredSession) {
+		t.Errorf("got %v, want %v", err, ErrExpiredSession)
+	}
+}
+
+func TestClient_Cancellation(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	c := New(slow.URL, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Authenticate(ctx, "token"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("context timeout: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	c = New(slow.URL, Options{HTTPClient: &http.Client{Timeout: 20 * time.Millisecond}})
+	_, err := c.Authenticate(context.Background(), "token")
+	var timeout interface{ Timeout() bool }
+	if !errors.As(err, &timeout) || !timeout.Timeout() {
+		t.Errorf("client timeout: got %v, want a timeout error", err)
+	}
+}
+
+func TestClient_Refresh(t *testing.T) {
+	for name, transport := range map[string]Transport{"cookie": CookieTransport, "bearer": BearerTransport} {
+		t.Run(name, func(t *testing.T) {
+			config := session.DefaultConfig()
+			config.RefreshTokenLifetime = time.Hour
+			ts, _ := setupServer(t, config)
+			c := New(ts.URL, Options{Transport: transport})
+			ctx := context.Background()
+
+			login, err := c.SignUp(ctx, "user@example.com", "password")
+			if err != nil {
+				t.Fatalf("SignUp: %v", err)
+			}
+			if login.RefreshToken == "" {
+				t.Fatalf("expected a refresh token, got %+v", login)
+			}
+
+			refreshed, err := c.Refresh(ctx, login.RefreshToken)
+			if err != nil {
+				t.Fatalf("Refresh: %v", err)
+			}
+			if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+				t.Errorf("expected a new refresh token, got %+v", refreshed)
+			}
+			if _, err := c.Authenticate(ctx, refreshed.Token); err != nil {
+				t.Errorf("Authenticate: %v", err)
+			}
+
+			if _, err := c.Refresh(ctx, login.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Errorf("expected ErrRefreshTokenReused, got %v", err)
+			}
+			if _, err := c.Authenticate(ctx, refreshed.Token); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("expected the family to be logged out, got %v", err)
+			}
+			if _, err := c.Refresh(ctx, refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+			}
+		})
+	}
+}
+
+// tokenMailer keeps the verification tokens instead of sending them
+type tokenMailer map[string]string
+
+func (m tokenMailer) SendVerification(email, token string) error {
+	m[email] = token
+	return nil
+}
+
+func (m tokenMailer) SendAccountExists(email string) error { return nil }
+
+func TestClient_VerifyEmail(t *testing.T) {
+	sessionStore := session.NewMemoryStore()
+	mailer := tokenMailer{}
+	authConfig := auth.DefaultConfig()
+	authConfig.Hardened = true
+	authConfig.Mailer = mailer
+	serverConfig := server.DefaultConfig()
+	serverConfig.Hardened = true
+	srv := server.New(session.New(sessionStore, session.DefaultConfig()), auth.New(auth.NewMemoryStore(sessionStore), authConfig), serverConfig)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	c := New(ts.URL, Options{})
+	ctx := context.Background()
+
+	if _, err := c.SignUp(ctx, "user@example.com", "password"); !errors.Is(err, ErrVerificationPending) {
+		t.Fatalf("expected ErrVerificationPending, got %v", err)
+	}
+	if _, err := c.Login(ctx, "user@example.com", "password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials before verifying, got %v", err)
+	}
+	if err := c.VerifyEmail(ctx, "wrong"); !errors.Is(err, ErrInvalidVerification) {
+		t.Errorf("expected ErrInvalidVerification, got %v", err)
+	}
+	if err := c.VerifyEmail(ctx, mailer["user@example.com"]); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if _, err := c.Login(ctx, "user@example.com", "password"); err != nil {
+		t.Errorf("Login: %v", err)
+	}
+}
+
+func TestClient_Lockout(t *testing.T) {
+	ts, authService := setupServer(t, session.DefaultConfig())
+	c := New(ts.URL, Options{})
+	ctx := context.Background()
+
+	if _, err := c.SignUp(ctx, "user@example.com", "password"); err != nil {
+		t.Fatalf("SignUp: %v", err)
+	}
+	for i := 0; i < auth.DefaultMaxFailures; i++ {
+		if _, err := c.Login(ctx, "user@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i, err)
+		}
+	}
+
+	_, err := c.Login(ctx, "user@example.com", "password")
+	var apiErr *Error
+	if !errors.Is(err, ErrAccountTemporarilyLocked) || !errors.As(err, &apiErr) {
+		t.Fatalf("expected ErrAccountTemporarilyLocked, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusLocked || apiErr.RetryAfter <= 0 || apiErr.RetryAfter > auth.DefaultLockout {
+		t.Errorf("expected a 423 with Retry-After, got %d after %v", apiErr.StatusCode, apiErr.RetryAfter)
+	}
+
+	if err := authService.UnlockUser("user@example.com"); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if _, err := c.Login(ctx, "user@example.com", "password"); err != nil {
+		t.Errorf("Login: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/internal/database"
+)
+
+const importUsage = `Usage: auth-session import [flags] [file]
+
+Import users along with their password hashes, from the file or the standard input.
+Hashes are not recomputed, they are upgraded to argon2id on the first sign in.
+Supported hashes: argon2id PHC strings, bcrypt ($2a$, $2b$, $2y$), Django
+pbkdf2_sha256 and scrypt. Users whose email already exists are skipped.
+
+Flags:
+  -format   csv (default) or jsonl
+  -batch    users inserted per transaction, defaults to 1000
+
+CSV files start with a header naming the email and password_hash columns, and
+optionally locked. JSONL files hold one object per line with the same keys:
+  {"email": "user@example.com", "password_hash": "$2a$12$...", "locked": false}
+`
+
+// importRecord is a user read from the import file
+type importRecord struct {
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+	Locked       bool   `json:"locked"`
+}
+
+// importUsers runs the import command
+func importUsers(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, importUsage) }
+	format := flags.String("format", "csv", "csv or jsonl")
+	batchSize := flags.Int("batch", 1000, "users inserted per transaction")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || *batchSize < 1 {
+		return errors.New(importUsage)
+	}
+	if database.InMemory() {
+		return errors.New("import needs a persistent database")
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var read func(io.Reader, func(line int, record importRecord, err error)) error
+	switch *format {
+	case "csv":
+		read = readCSV
+	case "jsonl":
+		read = readJSONL
+	default:
+		return fmt.Errorf("unknown import format: %s\n\n%s", *format, importUsage)
+	}
+
+	stores, err := openStores()
+	if err != nil {
+		return err
+	}
+	defer stores.close()
+	authConfig, err := authConfig()
+	if err != nil {
+		return err
+	}
+	authService := auth.New(stores.users, authConfig)
+
+	// Invalid records are reported and skipped, the others are imported batch by batch
+	var batch []*auth.User
+	total, imported, rejected := 0, 0, 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		count, err := authService.Import(batch)
+		if err != nil {
+			return err
+		}
+		imported += count
+		batch = batch[:0]
+		return nil
+	}
+
+	var importErr error
+	err = read(input, func(line int, record importRecord, err error) {
+		if importErr != nil {
+			return
+		}
+		total++
+		user := &auth.User{Email: record.Email, Password: []byte(record.PasswordHash), Locked: record.Locked}
+		if err == nil {
+			err = auth.CheckImport(user)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			rejected++
+			return
+		}
+
+		batch = append(batch, user)
+		if len(batch) >= *batchSize {
+			importErr = flush()
+		}
+	})
+	if err == nil {
+		err = importErr
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("import stopped after %d users: %w", imported, err)
+	}
+
+	fmt.Printf("imported %d users, %d already existed, %d rejected\n", imported, total-imported-rejected, rejected)
+	return nil
+}
+
+// readCSV reads the records of a CSV file with a header
+func readCSV(input io.Reader, handle func(line int, record importRecord, err error)) error {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("could not read the CSV header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	emailColumn, ok := columns["email"]
+	if !ok {
+		return errors.New("the CSV header has no email column")
+	}
+	hashColumn, ok := columns["password_hash"]
+	if !ok {
+		return errors.New("the CSV header has no password_hash column")
+	}
+	lockedColumn, hasLocked := columns["locked"]
+
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			handle(parseErr.Line, importRecord{}, err)
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(fields) != len(header) {
+			handle(line, importRecord{}, fmt.Errorf("expected %d fields, got %d", len(header), len(fields)))
+			continue
+		}
+
+		record := importRecord{Email: fields[emailColumn], PasswordHash: fields[hashColumn]}
+		if hasLocked && fields[lockedColumn] != "" {
+			record.Locked, err = strconv.ParseBool(fields[lockedColumn])
+		}
+		handle(line, record, err)
+	}
+}
+
+// readJSONL reads the records of a file holding a JSON object per line
+func readJSONL(input io.Reader, handle func(line int, record importRecord, err error)) error {
+	scanner := bufio.NewScanner(input)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record importRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		handle(line, record, err)
+	}
+	return scanner.Err()
+}
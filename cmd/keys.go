@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aloysb/auth-session/internal/utils"
+	"github.com/aloysb/auth-session/keys"
+)
+
+const keysUsage = `Usage: auth-session keys <command> [flags]
+
+Commands:
+  generate  add a new key, published for verification but not signing yet.
+            Without a directory, print a seed for SESSION_SIGNING_KEY instead
+  rotate    make a generated key sign new tokens, the previous key keeps verifying
+  list      list the keys of the directory
+
+Flags:
+  -dir      key directory, defaults to SESSION_KEYS_DIR
+  -kid      key to activate with rotate, defaults to the newest key
+
+Rotating without downtime: generate a key, wait for the servers to reload it
+(SESSION_KEYS_RELOAD_INTERVAL) and for the JWKS caches to expire, then rotate.
+Remove the previous key once the tokens it signed have expired.
+`
+
+// keysCommand manages the keys signing session tokens
+func keysCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("keys "+command, flag.ExitOnError)
+	dir := flags.String("dir", utils.GetEnv("SESSION_KEYS_DIR", ""), "key directory")
+	kid := flags.String("kid", "", "key to activate")
+	flags.Parse(args[1:])
+
+	switch command {
+	case "generate":
+		if *dir == "" {
+			seed, err := keys.GenerateSeed()
+			if err != nil {
+				return err
+			}
+			fmt.Println(seed)
+			return nil
+		}
+		id, err := keys.Generate(*dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("generated: %s\n", id)
+		return nil
+	case "rotate":
+		if *dir == "" {
+			return errors.New("rotate needs a key directory, with SESSION_SIGNING_KEY put the new seed first instead")
+		}
+		return rotateKeys(*dir, *kid)
+	case "list":
+		if *dir == "" {
+			return errors.New("list needs a key directory")
+		}
+		list, err := keys.List(*dir)
+		if err != nil {
+			return err
+		}
+		for _, key := range list {
+			status := ""
+			if key.Active {
+				status = "active"
+			}
+			fmt.Printf("%s  %s  %s\n", key.Id, key.CreatedAt.Format("2006-01-02 15:04:05"), status)
+		}
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return fmt.Errorf("unknown keys command: %s", command)
+	}
+}
+
+// rotateKeys activates the given key, or the newest one
+func rotateKeys(dir, kid string) error {
+	if kid == "" {
+		list, err := keys.List(dir)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 || list[len(list)-1].Active {
+			return errors.New("no key to rotate to, run keys generate first and wait for the servers to reload it")
+		}
+		kid = list[len(list)-1].Id
+	}
+
+	if err := keys.Activate(dir, kid); err != nil {
+		return err
+	}
+	fmt.Printf("active: %s\n", kid)
+	return nil
+}
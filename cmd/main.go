@@ -1,21 +1,343 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/aloysb/auth-session/internal/auth"
 	"github.com/aloysb/auth-session/internal/database"
 	"github.com/aloysb/auth-session/internal/server"
-	"github.com/aloysb/auth-session/internal/session"
+	"github.com/aloysb/auth-session/internal/utils"
+	"github.com/aloysb/auth-session/keys"
+	"github.com/aloysb/auth-session/session"
 )
 
+// How long in-flight requests get to finish once a shutdown signal is received
+const shutdownTimeout = 10 * time.Second
+
+const usage = `Usage: auth-session [command] [flags]
+
+Commands:
+  serve     start the HTTP server (default)
+  migrate   apply pending database migrations
+  user      lock or unlock a user account
+  import    import users and their password hashes from CSV or JSONL
+  keys      generate and rotate the keys signing session tokens
+`
+
 func main() {
-	db, err := database.Init()
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serve()
+	case "migrate":
+		err = migrate(args)
+	case "user":
+		err = user(args)
+	case "import":
+		err = importUsers(args)
+	case "keys":
+		err = keysCommand(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func serve() error {
+	stores, err := openStores()
+	if err != nil {
+		return err
 	}
 	// Ensure the database connection is closed when the program exits
-	defer db.Close()
-	sessionService := session.New(db)
-	basicAuthService := auth.New(db)
-	srv := server.New(sessionService, basicAuthService)
+	defer stores.close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	signingKeys, err := loadKeys()
+	if err != nil {
+		return err
+	}
+	config := sessionConfig(signingKeys)
+	baseSessionService := session.New(stores.sessions, config)
+	var sessionService session.ISessionService = baseSessionService
+	authConfig, err := authConfig()
+	if err != nil {
+		return err
+	}
+	basicAuthService := auth.New(stores.users, authConfig)
+	// Signed tokens outlive their session, revoke them along with password changes and locks
+	basicAuthService.OnSessionsRevoked(baseSessionService)
+	// Tell the caches of the other replicas about the sessions the auth service revokes
+	basicAuthService.OnSessionsRevoked(session.NotifyBus(stores.bus))
+	var background sync.WaitGroup
+
+	// Cache validated sessions in process, SESSION_CACHE_TTL=0 (default) disables the cache
+	if cacheTTL := utils.GetEnvDuration("SESSION_CACHE_TTL", 0); cacheTTL > 0 {
+		cache := session.NewCache(sessionService, session.CacheConfig{
+			TTL:  cacheTTL,
+			Size: utils.GetEnvInt("SESSION_CACHE_SIZE", session.DefaultCacheSize),
+		})
+		basicAuthService.OnSessionsRevoked(cache)
+		sessionService = cache
+		defer func() {
+			stats := cache.Stats()
+			slog.Info("Session cache", "hits", stats.Hits, "misses", stats.Misses, "size", stats.Size)
+		}()
+
+		// Drop the sessions revoked by the other replicas
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := stores.bus.Listen(ctx, cache.Apply); err != nil {
+				slog.Error("Session invalidations are not received, cached sessions are only refreshed by the TTL", "error", err)
+			}
+		}()
+	}
+	// Tell the caches of the other replicas about the sessions revoked through this one,
+	// whether or not it caches sessions
+	sessionService = session.NewPublisher(sessionService, stores.bus)
+	// Verifiers may cache the published keys for as long as the server takes to load new ones
+	keysReloadInterval := utils.GetEnvDuration("SESSION_KEYS_RELOAD_INTERVAL", keys.DefaultReloadInterval)
+	cookie, err := cookieConfig()
+	if err != nil {
+		return err
+	}
+	srv := server.New(sessionService, basicAuthService, server.Config{
+		TrustProxyHeaders:  utils.GetEnvBool("TRUST_PROXY_HEADERS", false),
+		TrustedProxies:     utils.GetEnvInt("TRUSTED_PROXY_HOPS", 1),
+		Cookie:             cookie,
+		Keys:               signingKeys,
+		KeysReloadInterval: keysReloadInterval,
+		RefreshTokens:      config.RefreshTokenLifetime > 0,
+		Hardened:           authConfig.Hardened,
+		ForwardAuth: server.ForwardAuthConfig{
+			LoginURL:    utils.GetEnv("FORWARD_AUTH_LOGIN_URL", ""),
+			ReturnParam: utils.GetEnv("FORWARD_AUTH_RETURN_PARAM", server.DefaultReturnParam),
+		},
+	})
+
+	// Purge expired sessions, verifications and login attempts in the background, SESSION_SWEEP_INTERVAL=0
+	// disables the sweepers
+	sweepInterval := utils.GetEnvDuration("SESSION_SWEEP_INTERVAL", session.DefaultSweepInterval)
+	if sweepInterval > 0 {
+		sweeper := session.NewSweeper(stores.sessions, sweepInterval, utils.GetEnvInt("SESSION_SWEEP_BATCH_SIZE", session.DefaultSweepBatchSize))
+		userSweeper := auth.NewSweeper(stores.users, sweepInterval, authConfig.Lockout.Window)
+		background.Add(2)
+		go func() {
+			defer background.Done()
+			sweeper.Run(ctx)
+		}()
+		go func() {
+			defer background.Done()
+			userSweeper.Run(ctx)
+		}()
+	}
+
+	// Pick up keys generated and activated with the keys command
+	if signingKeys != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			signingKeys.Run(ctx, keysReloadInterval)
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down server", "error", err)
+		}
+	}()
+
 	srv.Start(8080)
+	stop()
+	background.Wait()
+	return nil
+}
+
+// sessionConfig reads the session lifetimes, tokens are signed when keys are given
+func sessionConfig(signingKeys *keys.Manager) session.Config {
+	config := session.Config{
+		IdleTimeout:      utils.GetEnvDuration("SESSION_IDLE_TIMEOUT", session.DefaultIdleTimeout),
+		AbsoluteLifetime: utils.GetEnvDuration("SESSION_ABSOLUTE_LIFETIME", 0),
+		RefreshThreshold: utils.GetEnvDuration("SESSION_REFRESH_THRESHOLD", 0),
+		TouchInterval:    utils.GetEnvDuration("SESSION_TOUCH_INTERVAL", session.DefaultTouchInterval),
+		// Read by every command, the user CLI revokes the refresh tokens along with the sessions
+		RefreshTokenLifetime: utils.GetEnvDuration("SESSION_REFRESH_TOKEN_LIFETIME", 0),
+	}
+	if signingKeys != nil {
+		config.Keys = signingKeys
+	}
+	return config
+}
+
+// loadKeys reads the signing keys of the signed token mode, from SESSION_KEYS_DIR or
+// SESSION_SIGNING_KEY. Opaque tokens need no keys, it then returns nil.
+func loadKeys() (*keys.Manager, error) {
+	switch mode := strings.ToLower(utils.GetEnv("SESSION_TOKEN_MODE", "opaque")); mode {
+	case "opaque":
+		return nil, nil
+	case "signed":
+	default:
+		return nil, fmt.Errorf("unknown SESSION_TOKEN_MODE: %s", mode)
+	}
+
+	if dir := utils.GetEnv("SESSION_KEYS_DIR", ""); dir != "" {
+		manager, err := keys.FromDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SESSION_KEYS_DIR: %w", err)
+		}
+		return manager, nil
+	}
+
+	manager, err := keys.FromSeeds(utils.GetEnv("SESSION_SIGNING_KEY", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SESSION_SIGNING_KEY: %w", err)
+	}
+	return manager, nil
+}
+
+// authConfig reads the argon2id parameters of new password hashes
+func authConfig() (auth.Config, error) {
+	defaults := auth.DefaultArgon2Params()
+	config := auth.Config{
+		Argon2: auth.Argon2Params{
+			Memory:      uint32(utils.GetEnvInt("PASSWORD_ARGON2_MEMORY", int(defaults.Memory))),
+			Iterations:  uint32(utils.GetEnvInt("PASSWORD_ARGON2_ITERATIONS", int(defaults.Iterations))),
+			Parallelism: uint8(utils.GetEnvInt("PASSWORD_ARGON2_PARALLELISM", int(defaults.Parallelism))),
+			SaltLength:  defaults.SaltLength,
+			KeyLength:   defaults.KeyLength,
+		},
+		Policy: auth.PasswordPolicy{
+			MinLength: utils.GetEnvInt("PASSWORD_MIN_LENGTH", auth.DefaultMinPasswordLength),
+			MaxLength: utils.GetEnvInt("PASSWORD_MAX_LENGTH", auth.DefaultMaxPasswordLength),
+		},
+		Lockout: auth.LockoutConfig{
+			MaxFailures:   utils.GetEnvInt("LOGIN_MAX_FAILURES", auth.DefaultMaxFailures),
+			MaxIPFailures: utils.GetEnvInt("LOGIN_MAX_IP_FAILURES", auth.DefaultMaxIPFailures),
+			Window:        utils.GetEnvDuration("LOGIN_FAILURE_WINDOW", auth.DefaultFailureWindow),
+			Lockout:       utils.GetEnvDuration("LOGIN_LOCKOUT", auth.DefaultLockout),
+			MaxLockout:    utils.GetEnvDuration("LOGIN_MAX_LOCKOUT", auth.DefaultMaxLockout),
+		},
+		Hardened:             utils.GetEnvBool("AUTH_HARDENED", false),
+		VerificationLifetime: utils.GetEnvDuration("AUTH_VERIFICATION_LIFETIME", auth.DefaultVerificationLifetime),
+	}
+	if err := config.Argon2.Validate(); err != nil {
+		return config, fmt.Errorf("invalid password hashing parameters: %w", err)
+	}
+	if path := utils.GetEnv("PASSWORD_BREACHED_FILE", ""); path != "" {
+		breached, err := auth.LoadBreachedList(path)
+		if err != nil {
+			return config, err
+		}
+		slog.Info("Loaded breached passwords", "hashes", breached.Len())
+		config.Policy.Breached = breached
+	}
+
+	// Verification emails are logged unless an SMTP server is configured
+	if addr := utils.GetEnv("SMTP_ADDR", ""); addr != "" {
+		mailer := &auth.SMTPMailer{
+			Addr:      addr,
+			Username:  utils.GetEnv("SMTP_USERNAME", ""),
+			Password:  utils.GetEnv("SMTP_PASSWORD", ""),
+			From:      utils.GetEnv("SMTP_FROM", ""),
+			VerifyURL: utils.GetEnv("AUTH_VERIFY_URL", ""),
+		}
+		if mailer.From == "" || mailer.VerifyURL == "" {
+			return config, errors.New("SMTP_FROM and AUTH_VERIFY_URL are required with SMTP_ADDR")
+		}
+		config.Mailer = mailer
+	}
+	return config, nil
+}
+
+// cookieConfig reads the session cookie attributes, cookies are Secure unless disabled explicitly
+func cookieConfig() (server.CookieConfig, error) {
+	defaults := server.DefaultCookieConfig()
+	sameSite, err := server.ParseSameSite(utils.GetEnv("COOKIE_SAMESITE", "lax"))
+	if err != nil {
+		return defaults, err
+	}
+
+	cookie := server.CookieConfig{
+		Name:       utils.GetEnv("COOKIE_NAME", defaults.Name),
+		Domain:     utils.GetEnv("COOKIE_DOMAIN", defaults.Domain),
+		Path:       utils.GetEnv("COOKIE_PATH", defaults.Path),
+		Secure:     utils.GetEnvBool("COOKIE_SECURE", defaults.Secure),
+		SameSite:   sameSite,
+		Persistent: utils.GetEnvBool("COOKIE_PERSISTENT", defaults.Persistent),
+		HostPrefix: utils.GetEnvBool("COOKIE_HOST_PREFIX", defaults.HostPrefix),
+	}
+	if err := cookie.Validate(); err != nil {
+		return defaults, fmt.Errorf("invalid cookie configuration: %w", err)
+	}
+	if !cookie.Secure {
+		slog.Warn("Session cookies are not Secure, only use COOKIE_SECURE=false for local development")
+	}
+	return cookie, nil
+}
+
+// stores bundles the backends of the configured database
+type stores struct {
+	sessions session.SessionStore
+	users    auth.UserStore
+	// bus broadcasts session invalidations to the other processes using the database
+	bus   session.InvalidationBus
+	close func()
+}
+
+// openStores sets up the stores for the configured backend
+func openStores() (*stores, error) {
+	if database.InMemory() {
+		slog.Warn("Using in-memory stores, sessions and users are lost on restart")
+		sessionStore := session.NewMemoryStore()
+		return &stores{
+			sessions: sessionStore,
+			users:    auth.NewMemoryStore(sessionStore),
+			bus:      session.NopBus{},
+			close:    func() {},
+		}, nil
+	}
+
+	db, dialect, err := database.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only postgres can reach the other replicas, a SQLite file has a single writer anyway
+	var bus session.InvalidationBus = session.NopBus{}
+	if dialect == database.PostgresDialect {
+		bus = session.NewPostgresBus(db, utils.GetEnv("DATABASE_URL", ""))
+	}
+
+	return &stores{
+		sessions: session.NewSQLStore(db),
+		users:    auth.NewSQLStore(db),
+		bus:      bus,
+		close:    func() { db.Close() },
+	}, nil
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aloysb/auth-session/internal/database"
+)
+
+// migrate applies pending migrations, or only lists them with -dry-run.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list pending migrations without applying them")
+	flags.Parse(args)
+
+	db, dialect, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *dryRun {
+		pending, err := database.PendingMigrations(db, dialect)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		for _, migration := range pending {
+			fmt.Printf("pending: %04d_%s\n", migration.Version, migration.Name)
+		}
+		return nil
+	}
+
+	applied, err := database.Migrate(db, dialect)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Database is up to date")
+	}
+	for _, migration := range applied {
+		fmt.Printf("applied: %04d_%s\n", migration.Version, migration.Name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/internal/database"
+	"github.com/aloysb/auth-session/keys"
+	"github.com/aloysb/auth-session/session"
+)
+
+const userUsage = `Usage: auth-session user <command> <email>
+
+Commands:
+  lock      prevent the user from signing in and revoke all of their sessions
+  unlock    allow a locked user to sign in again, also lifting a lockout after failed sign ins
+`
+
+// user runs the account administration commands
+func user(args []string) error {
+	if len(args) != 2 {
+		return errors.New(userUsage)
+	}
+	if database.InMemory() {
+		return errors.New("user commands need a persistent database")
+	}
+
+	stores, err := openStores()
+	if err != nil {
+		return err
+	}
+	defer stores.close()
+	signingKeys, err := loadKeys()
+	if err != nil {
+		return err
+	}
+	authConfig, err := authConfig()
+	if err != nil {
+		return err
+	}
+	authService := userAuthService(stores, signingKeys, authConfig)
+
+	command, email := args[0], args[1]
+	switch command {
+	case "lock":
+		err = authService.LockUser(email)
+	case "unlock":
+		err = authService.UnlockUser(email)
+	default:
+		return fmt.Errorf("unknown user command: %s\n\n%s", command, userUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%sed %s\n", command, email)
+	return nil
+}
+
+// userAuthService returns the auth service of the user commands, revoking the signed
+// and refresh tokens of the sessions it revokes and letting running servers drop them
+// from their cache
+func userAuthService(stores *stores, signingKeys *keys.Manager, authConfig auth.Config) *auth.BasicAuthService {
+	authService := auth.New(stores.users, authConfig)
+	authService.OnSessionsRevoked(session.New(stores.sessions, sessionConfig(signingKeys)))
+	authService.OnSessionsRevoked(session.NotifyBus(stores.bus))
+	return authService
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/internal/database/dbtest"
+	"github.com/aloysb/auth-session/session"
+)
+
+func TestUserLock_RevokesRefreshTokens(t *testing.T) {
+	t.Setenv("SESSION_REFRESH_TOKEN_LIFETIME", "1h")
+	db := dbtest.SQLite(t)
+	stores := &stores{
+		sessions: session.NewSQLStore(db),
+		users:    auth.NewSQLStore(db),
+		bus:      session.NopBus{},
+		close:    func() {},
+	}
+	authConfig := auth.DefaultConfig()
+	authConfig.Argon2 = auth.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	authService := userAuthService(stores, nil, authConfig)
+	if err := authService.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Signed in on a running server
+	sessions := session.New(stores.sessions, sessionConfig(nil))
+	created, _, err := sessions.CreateSession("test@user.com", session.Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	refreshToken, _, err := sessions.IssueRefreshToken(created)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := authService.LockUser("test@user.com"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := sessions.Refresh(refreshToken, session.Metadata{}); !errors.Is(err, session.ErrInvalidRefreshToken) {
+		t.Errorf("expected the refresh token of a locked user to be revoked, got %v", err)
+	}
+}
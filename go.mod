@@ -3,7 +3,9 @@ module github.com/aloysb/auth-session
 go 1.22.3
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
-	golang.org/x/crypto v0.28.0 // indirect
-	golang.org/x/sys v0.26.0 // indirect
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/crypto v0.28.0
 )
+
+require golang.org/x/sys v0.26.0 // indirect
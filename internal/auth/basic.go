@@ -1,61 +1,130 @@
 package auth
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/mail"
+	"sync"
+	"time"
 
-	"github.com/aloysb/auth-session/internal/utils"
-	"golang.org/x/crypto/argon2"
-)
-
-const (
-	timeCost    = 1         // Time cost parameter
-	memoryCost  = 64 * 1024 // Memory cost parameter (64 MB)
-	parallelism = 4         // Number of parallel threads
-	keyLength   = 32        // Length of the key
+	"github.com/aloysb/auth-session/autherr"
+	"github.com/aloysb/auth-session/session"
 )
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrUserAlreadyExists  = errors.New("user already exists")
-	ErrInvalidEmail       = errors.New("invalid email")
-	ErrEmptyPassword      = errors.New("empty password")
+	ErrInvalidCredentials = autherr.ErrInvalidCredentials
+	ErrUserNotFound       = autherr.ErrUserNotFound
+	ErrUserAlreadyExists  = autherr.ErrUserAlreadyExists
+	ErrInvalidEmail       = autherr.ErrInvalidEmail
+	ErrEmptyPassword      = autherr.ErrEmptyPassword
+	ErrAccountLocked      = autherr.ErrAccountLocked
 )
 
 type IBasicAuthService interface {
-	SignIn(email, password string) error
+	SignIn(email, password, ip string) error
 	SignUp(email, password string) error
+	ChangePassword(email, currentPassword, newPassword string, currentSessionId session.SessionID) error
+	VerifyEmail(token string) error
 }
 
 type BasicAuthService struct {
-	db *sql.DB
+	store     UserStore
+	config    Config
+	listeners []session.RevocationListener
+	// dummy is hashed for unknown users in hardened mode, computed on first use
+	dummyOnce sync.Once
+	dummy     *User
 }
 
 type User struct {
-	Id       string
-	Email    string
+	Id    string
+	Email string
+	// Password is the PHC string of the password hash, or a raw legacy hash along with Salt
 	Password []byte
-	Salt     []byte
+	// Salt is only set for legacy hashes, PHC strings embed their salt
+	Salt   []byte
+	Locked bool
 }
 
-func New(db *sql.DB) *BasicAuthService {
-	return &BasicAuthService{db}
+// Config controls how passwords are hashed and how accounts are created
+type Config struct {
+	// Argon2 are the parameters of new hashes, older ones are rehashed on sign in
+	Argon2 Argon2Params
+	// Policy is checked on the passwords chosen at sign up and password change
+	Policy PasswordPolicy
+	// Lockout limits failed sign ins, the zero value disables the limits
+	Lockout LockoutConfig
+	// Hardened hides which emails have an account: signing in with an unknown email
+	// costs a password hash and fails like a wrong password, and signing up always
+	// answers the same way, the outcome is sent to the email instead
+	Hardened bool
+	// Mailer sends the emails of hardened sign ups, defaults to logging them
+	Mailer Mailer
+	// VerificationLifetime is how long an email verification link stays valid
+	VerificationLifetime time.Duration
+}
+
+// DefaultConfig returns the default argon2id parameters, without hardening
+func DefaultConfig() Config {
+	return Config{
+		Argon2:               DefaultArgon2Params(),
+		Policy:               DefaultPasswordPolicy(),
+		Lockout:              DefaultLockoutConfig(),
+		VerificationLifetime: DefaultVerificationLifetime,
+	}
 }
 
+func New(store UserStore, config Config) *BasicAuthService {
+	if config.Argon2 == (Argon2Params{}) {
+		config.Argon2 = DefaultArgon2Params()
+	}
+	if config.Policy == (PasswordPolicy{}) {
+		config.Policy = DefaultPasswordPolicy()
+	}
+	if config.Lockout.Window <= 0 {
+		config.Lockout.Window = DefaultFailureWindow
+	}
+	if config.Lockout.Lockout <= 0 {
+		config.Lockout.Lockout = DefaultLockout
+	}
+	if config.Mailer == nil {
+		config.Mailer = LogMailer{}
+	}
+	if config.VerificationLifetime <= 0 {
+		config.VerificationLifetime = DefaultVerificationLifetime
+	}
+	return &BasicAuthService{store: store, config: config}
+}
+
+// OnSessionsRevoked registers a listener told about the sessions the store revokes
+// along with a password change or an account lock, e.g. a session cache.
+// It must be called before the service is used.
+func (b *BasicAuthService) OnSessionsRevoked(listener session.RevocationListener) {
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *BasicAuthService) sessionsRevoked(email string, exceptId session.SessionID) {
+	for _, listener := range b.listeners {
+		listener.SessionsRevoked(email, exceptId)
+	}
+}
+
+// SignUp creates a user. In hardened mode the user has to verify their email
+// before signing in, and an existing email is not reported, see signUpHardened.
 func (b *BasicAuthService) SignUp(email, password string) error {
+	if b.config.Hardened {
+		return b.signUpHardened(email, password)
+	}
+
 	// Check if the email already exists
-	row := b.db.QueryRow("SELECT id FROM users WHERE email = $1", email)
-	var id sql.NullString
-	err := row.Scan(&id)
+	_, err := b.store.GetByEmail(email)
 
 	if err == nil {
 		return ErrUserAlreadyExists
 	}
 
-	if err != nil && err != sql.ErrNoRows {
+	if !errors.Is(err, ErrUserNotFound) {
 		return fmt.Errorf("could not query user: %w", err)
 	}
 
@@ -67,51 +136,166 @@ func (b *BasicAuthService) SignUp(email, password string) error {
 		return ErrEmptyPassword
 	}
 
+	if err := b.config.Policy.Check(email, password); err != nil {
+		return err
+	}
+
 	// Hash the password
-	salt := generateSalt()
-	hashedPassword := hashPassword(password, salt)
+	hashedPassword, err := hashPassword(password, b.config.Argon2)
+	if err != nil {
+		return err
+	}
 
-	// Save the new user to the database
-	if _, err := b.db.Exec("INSERT INTO users (email, password, salt) VALUES ($1, $2, $3)", email, hashedPassword, salt); err != nil {
+	// Save the new user to the store
+	user := &User{Email: email, Password: hashedPassword}
+	if err := b.store.Create(user); err != nil {
 		return fmt.Errorf("could not insert user: %w", err)
 	}
 
 	return nil
 }
 
-func (b *BasicAuthService) SignIn(email, password string) error {
-	var storedPassword, storedSalt string
-	row := b.db.QueryRow("SELECT password, salt FROM users WHERE email = $1", email)
+// SignIn checks the password of a user signing in from the given client IP, which
+// may be empty when unknown. Failures are limited per account and per IP, see
+// LockoutConfig: once locked out, it returns a LockoutError without checking the password.
+// The attempt is counted before the password is checked, so that a burst of parallel
+// attempts cannot exceed the limits.
+func (b *BasicAuthService) SignIn(email, password, ip string) error {
+	now := time.Now()
+	if err := b.reserveAttempt(email, ip, now); err != nil {
+		return err
+	}
+
+	err := b.checkPassword(email, password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrUserNotFound):
+		b.recordFailure(email, ip, now)
+	default:
+		b.releaseAttempt(email, ip, err == nil)
+	}
+	return err
+}
 
-	err := row.Scan(&storedPassword, &storedSalt)
+// checkPassword checks the password of a user. The state of the account is only
+// reported once the password matched.
+func (b *BasicAuthService) checkPassword(email, password string) error {
+	user, err := b.store.GetByEmail(email)
 	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
+		switch {
+		case errors.Is(err, ErrUserNotFound) && b.config.Hardened:
+			// Take as long as a wrong password
+			verifyPassword(password, b.dummyUser(), b.config.Argon2)
+			return ErrInvalidCredentials
+		case errors.Is(err, ErrUserNotFound):
 			return ErrUserNotFound
 		default:
 			return fmt.Errorf("could not query user: %w", err)
 		}
 	}
-	if !comparePasswords(password, storedSalt, storedPassword) {
+	ok, rehash := verifyPassword(password, user, b.config.Argon2)
+	if !ok {
 		return ErrInvalidCredentials
 	}
+	if user.Locked {
+		return ErrAccountLocked
+	}
+
+	// Upgrade hashes computed with outdated parameters while the password is at hand
+	if rehash {
+		b.rehash(user, password)
+	}
 	return nil
 }
 
-func generateSalt() []byte {
-	str := utils.GenerateRandomString()
-	return []byte(str)
+// rehash replaces the hash of the user with one using the current parameters.
+// Failing to do so does not fail the sign in, it is attempted again on the next one.
+func (b *BasicAuthService) rehash(user *User, password string) {
+	hashedPassword, err := hashPassword(password, b.config.Argon2)
+	if err == nil {
+		err = b.store.UpdatePasswordHash(user.Email, user.Password, hashedPassword)
+	}
+	if err != nil {
+		slog.Error("could not rehash password", "email", user.Email, "error", err)
+	}
+}
+
+// Import saves users migrated from another system along with their password hashes.
+// The hashes are checked but not recomputed, they are upgraded to argon2id on the
+// first sign in. Users whose email already exists are skipped, it returns how many
+// were imported.
+func (b *BasicAuthService) Import(users []*User) (int, error) {
+	for _, user := range users {
+		if err := CheckImport(user); err != nil {
+			return 0, fmt.Errorf("%s: %w", user.Email, err)
+		}
+	}
+
+	imported, err := b.store.Import(users)
+	if err != nil {
+		return 0, fmt.Errorf("could not import users: %w", err)
+	}
+	return imported, nil
 }
 
-// HashPassword hashes the password with a salt
-func hashPassword(password string, salt []byte) []byte {
-	return argon2.IDKey([]byte(password), salt, timeCost, memoryCost, parallelism, keyLength)
+// CheckImport reports whether a user can be imported: a valid email, and a hash in
+// one of the supported formats, see CheckHash
+func CheckImport(user *User) error {
+	if !validEmail(user.Email) {
+		return ErrInvalidEmail
+	}
+	if len(user.Salt) > 0 {
+		return ErrUnsupportedHash
+	}
+	return CheckHash(user.Password)
 }
 
-// VerifyPassword checks if the provided password matches the stored hash
-func comparePasswords(password, storedSalt, storedHash string) bool {
-	hash := hashPassword(password, []byte(storedSalt))
-	return string(hash) == storedHash
+// ChangePassword replaces the password of a user after checking the current one.
+// Every other session of the user is revoked along with the update.
+func (b *BasicAuthService) ChangePassword(email, currentPassword, newPassword string, currentSessionId session.SessionID) error {
+	// A stolen session does not allow guessing the password either
+	if err := b.SignIn(email, currentPassword, ""); err != nil {
+		return err
+	}
+
+	if newPassword == "" {
+		return ErrEmptyPassword
+	}
+
+	if err := b.config.Policy.Check(email, newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(newPassword, b.config.Argon2)
+	if err != nil {
+		return err
+	}
+
+	if err := b.store.ChangePassword(email, hashedPassword, currentSessionId); err != nil {
+		return fmt.Errorf("could not update password: %w", err)
+	}
+	b.sessionsRevoked(email, currentSessionId)
+	return nil
+}
+
+// LockUser prevents a user from signing in and revokes all of their sessions
+func (b *BasicAuthService) LockUser(email string) error {
+	if err := b.store.SetLocked(email, true); err != nil {
+		return fmt.Errorf("could not lock user: %w", err)
+	}
+	b.sessionsRevoked(email, "")
+	return nil
+}
+
+// UnlockUser allows a locked user to sign in again, lifting their lockout
+// after failed sign ins as well
+func (b *BasicAuthService) UnlockUser(email string) error {
+	if err := b.store.SetLocked(email, false); err != nil {
+		return fmt.Errorf("could not unlock user: %w", err)
+	}
+	if err := b.store.DeleteLoginAttempts(accountAttemptsId(email)); err != nil {
+		return fmt.Errorf("could not reset login attempts: %w", err)
+	}
+	return nil
 }
 
 func validEmail(email string) bool {
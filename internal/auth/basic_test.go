@@ -1,71 +1,21 @@
 package auth
 
 import (
-	"database/sql"
-	"log"
-	"os"
-	"path/filepath"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3" // Import SQLite driver
+	"github.com/aloysb/auth-session/session"
+	"golang.org/x/crypto/argon2"
 )
 
-var dbFile = "test_auth.db"
-var Db *sql.DB
-
 func setupService() BasicAuthService {
-	// Create a temporary directory
-	tmpDir, err := os.MkdirTemp("", "test_sessions_")
-	if err != nil {
-		log.Fatalf("failed to set up test table: %s", err)
-	}
-
-	// Create a temporary file for the SQLite database
-	dbFile = filepath.Join(tmpDir, "test_sessions.db")
-
-	// Create a temporary directory
-	if err != nil {
-		log.Fatalf("failed to set up test table: %s", err)
-	}
-
-	// Create a temporary file for the SQLite database
-	dbFile = filepath.Join(tmpDir, "test_auth.db")
-	db, err := sql.Open("sqlite3", dbFile)
-	if err != nil {
-		log.Fatalf("failed to set up test table: %s", err)
-	}
-
-	// Drop the user table if it exists
-	_, err = db.Exec(`DROP TABLE IF EXISTS users`)
-	if err != nil {
-		log.Fatalf("failed to set up test table: %s", err)
-	}
-
-	_, err = db.Exec(`
-        CREATE TABLE users (
-          id SERIAL PRIMARY KEY,
-          email TEXT NOT NULL,
-          password TEXT NOT NULL,
-          salt TEXT NOT NULL
-       );
-   `)
-
-	if err != nil {
-		log.Fatalf("failed to set up test table: %s", err)
-	}
-
-	Db = db
-
-	return *New(db)
-}
-
-func teardownTestDB() {
-	os.Remove(dbFile) // Remove the database file after tests
+	return *New(NewMemoryStore(nil), DefaultConfig())
 }
 
 func TestSignUp_Valid(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
 	err := s.SignUp("test@user.com", "testpassword")
 	if err != nil {
@@ -75,7 +25,6 @@ func TestSignUp_Valid(t *testing.T) {
 
 func TestSignUp_AlreadyExists(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
 	err := s.SignUp("test@user.com", "testpassword")
 	if err != nil {
@@ -90,7 +39,6 @@ func TestSignUp_AlreadyExists(t *testing.T) {
 
 func TestSignUp_InvalidEmail(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
 	err := s.SignUp("invalidemail", "testpassword")
 	if err != ErrInvalidEmail {
@@ -105,14 +53,13 @@ func TestSignUp_InvalidEmail(t *testing.T) {
 
 func TestSignIn_Valid(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
 	err := s.SignUp("test@user.com", "testpassword")
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 
-	err = s.SignIn("test@user.com", "testpassword")
+	err = s.SignIn("test@user.com", "testpassword", "")
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
@@ -120,14 +67,13 @@ func TestSignIn_Valid(t *testing.T) {
 
 func TestSignIn_InvalidCredentials(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
 	err := s.SignUp("test@user.com", "testpassword")
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 
-	err = s.SignIn("test@user.com", "wrongpassword")
+	err = s.SignIn("test@user.com", "wrongpassword", "")
 	if err != ErrInvalidCredentials {
 		t.Errorf("expected ErrInvalidCredentials, got %v", err)
 	}
@@ -135,10 +81,220 @@ func TestSignIn_InvalidCredentials(t *testing.T) {
 
 func TestSignIn_NonexistentUser(t *testing.T) {
 	s := setupService()
-	defer teardownTestDB()
 
-	err := s.SignIn("nonexistent@user.com", "wrongpassword")
+	err := s.SignIn("nonexistent@user.com", "wrongpassword", "")
 	if err != ErrUserNotFound {
 		t.Errorf("expected ErrUserNotFound, got %v", err)
 	}
 }
+
+func TestChangePassword(t *testing.T) {
+	sessions := session.NewMemoryStore()
+	s := New(NewMemoryStore(sessions), DefaultConfig())
+
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, id := range []session.SessionID{"current", "other"} {
+		err := sessions.Create(&session.Session{Id: id, UserId: "test@user.com", ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+
+	err := s.ChangePassword("test@user.com", "wrongpassword", "newpassword", "current")
+	if err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := sessions.Get("other"); err != nil {
+		t.Errorf("expected sessions to be kept after a failed change, got %v", err)
+	}
+
+	err = s.ChangePassword("test@user.com", "testpassword", "newpassword", "current")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != ErrInvalidCredentials {
+		t.Errorf("expected the old password to be rejected, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "newpassword", ""); err != nil {
+		t.Errorf("expected the new password to be accepted, got %v", err)
+	}
+	if _, err := sessions.Get("current"); err != nil {
+		t.Errorf("expected the current session to be kept, got %v", err)
+	}
+	if _, err := sessions.Get("other"); err != session.ErrSessionNotFound {
+		t.Errorf("expected other sessions to be revoked, got %v", err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	s := setupService()
+
+	if err := s.SignUp("test@user.com", "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected ErrWeakPassword, got %v", err)
+	}
+	if _, err := s.store.GetByEmail("test@user.com"); err != ErrUserNotFound {
+		t.Errorf("expected no user to be created, got %v", err)
+	}
+
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := s.ChangePassword("test@user.com", "testpassword", "my test@user.com", "current")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Violations[0] != ViolationContainsEmail {
+		t.Errorf("expected the contains_email violation, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Errorf("expected the password to be kept, got %v", err)
+	}
+}
+
+func TestLockUser(t *testing.T) {
+	sessions := session.NewMemoryStore()
+	s := New(NewMemoryStore(sessions), DefaultConfig())
+
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := sessions.Create(&session.Session{Id: "current", UserId: "test@user.com", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	if err := s.LockUser("test@user.com"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != ErrAccountLocked {
+		t.Errorf("expected ErrAccountLocked, got %v", err)
+	}
+	if _, err := sessions.Get("current"); err != session.ErrSessionNotFound {
+		t.Errorf("expected sessions to be revoked on lock, got %v", err)
+	}
+
+	if err := s.UnlockUser("test@user.com"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Errorf("expected no error after unlock, got %v", err)
+	}
+
+	if err := s.LockUser("nonexistent@user.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+type revocationRecorder struct {
+	revoked []string
+}
+
+func (r *revocationRecorder) SessionsRevoked(userId string, exceptId session.SessionID) {
+	r.revoked = append(r.revoked, userId+"/"+string(exceptId))
+}
+
+func TestOnSessionsRevoked(t *testing.T) {
+	s := New(NewMemoryStore(session.NewMemoryStore()), DefaultConfig())
+	recorder := &revocationRecorder{}
+	s.OnSessionsRevoked(recorder)
+
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.ChangePassword("test@user.com", "wrongpassword", "newpassword", "current"); err == nil {
+		t.Fatalf("expected an error for a wrong password")
+	}
+	if err := s.ChangePassword("test@user.com", "testpassword", "newpassword", "current"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.LockUser("test@user.com"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := []string{"test@user.com/current", "test@user.com/"}
+	if len(recorder.revoked) != len(want) || recorder.revoked[0] != want[0] || recorder.revoked[1] != want[1] {
+		t.Errorf("expected revocations %v, got %v", want, recorder.revoked)
+	}
+}
+
+func TestSignIn_Rehash(t *testing.T) {
+	store := NewMemoryStore(nil)
+	s := New(store, Config{Argon2: testParams})
+
+	// A user from before PHC strings
+	salt := []byte("legacysalt")
+	legacy := argon2.IDKey([]byte("testpassword"), salt, legacyTimeCost, legacyMemoryCost, legacyParallelism, legacyKeyLength)
+	if err := store.Create(&User{Email: "test@user.com", Password: legacy, Salt: salt}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	if err := s.SignIn("test@user.com", "wrongpassword", ""); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	user, _ := store.GetByEmail("test@user.com")
+	if len(user.Salt) == 0 {
+		t.Fatalf("expected a failed sign in to keep the hash")
+	}
+
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	user, _ = store.GetByEmail("test@user.com")
+	if len(user.Salt) != 0 || !strings.HasPrefix(string(user.Password), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("expected the legacy hash to be upgraded, got %s", user.Password)
+	}
+
+	// Raising the cost upgrades the hash on the next sign in
+	stronger := testParams
+	stronger.Iterations = 2
+	s = New(store, Config{Argon2: stronger})
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	user, _ = store.GetByEmail("test@user.com")
+	if !strings.HasPrefix(string(user.Password), "$argon2id$v=19$m=64,t=2,p=1$") {
+		t.Errorf("expected the hash to use the new parameters, got %s", user.Password)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Errorf("expected the rehashed password to be accepted, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	store := NewMemoryStore(nil)
+	s := New(store, Config{Argon2: testParams})
+
+	users := []*User{
+		{Email: "django@user.com", Password: []byte(importedHashes["pbkdf2_sha256"])},
+		{Email: "php@user.com", Password: []byte(importedHashes["bcrypt $2y$"]), Locked: true},
+	}
+	imported, err := s.Import(users)
+	if err != nil || imported != 2 {
+		t.Fatalf("Import: got (%d, %v), want (2, nil)", imported, err)
+	}
+	if imported, err := s.Import(users[:1]); err != nil || imported != 0 {
+		t.Errorf("expected existing users to be skipped, got (%d, %v)", imported, err)
+	}
+	if _, err := s.Import([]*User{{Email: "plain@user.com", Password: []byte("password")}}); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("expected ErrUnsupportedHash, got %v", err)
+	}
+	if _, err := s.Import([]*User{{Email: "invalid", Password: []byte(importedHashes["scrypt"])}}); !errors.Is(err, ErrInvalidEmail) {
+		t.Errorf("expected ErrInvalidEmail, got %v", err)
+	}
+
+	// The imported hash is upgraded on the first sign in
+	if err := s.SignIn("django@user.com", "password", ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	user, _ := store.GetByEmail("django@user.com")
+	if !strings.HasPrefix(string(user.Password), "$argon2id$") {
+		t.Errorf("expected the hash to be upgraded to argon2id, got %s", user.Password)
+	}
+	if err := s.SignIn("django@user.com", "password", ""); err != nil {
+		t.Errorf("expected the upgraded hash to be accepted, got %v", err)
+	}
+	if err := s.SignIn("php@user.com", "rasmuslerdorf", ""); err != ErrAccountLocked {
+		t.Errorf("expected the imported lock to be kept, got %v", err)
+	}
+}
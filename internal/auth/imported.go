@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrUnsupportedHash is returned when importing a password hash no format recognises
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+// hashFormat verifies the password hashes of another system, recognised by their prefix.
+// They are upgraded to argon2id on the first sign in.
+type hashFormat struct {
+	prefixes []string
+	// check parses the hash without computing it
+	check  func(encoded string) error
+	verify func(password, encoded string) bool
+}
+
+// importedFormats are the formats accepted by Import besides argon2id PHC strings
+var importedFormats = []hashFormat{
+	{
+		// Rails has_secure_password and Devise, PHP password_hash
+		prefixes: []string{"$2a$", "$2b$", "$2y$"},
+		check: func(encoded string) error {
+			_, err := bcrypt.Cost([]byte(encoded))
+			return err
+		},
+		verify: func(password, encoded string) bool {
+			return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+		},
+	},
+	{
+		// Django PBKDF2PasswordHasher: pbkdf2_sha256$<iterations>$<salt>$<hash>
+		prefixes: []string{"pbkdf2_sha256$"},
+		check: func(encoded string) error {
+			_, _, _, err := parsePBKDF2(encoded)
+			return err
+		},
+		verify: func(password, encoded string) bool {
+			iterations, salt, key, err := parsePBKDF2(encoded)
+			if err != nil {
+				return false
+			}
+			computed := pbkdf2.Key([]byte(password), []byte(salt), iterations, len(key), sha256.New)
+			return subtle.ConstantTimeCompare(computed, key) == 1
+		},
+	},
+	{
+		// Django ScryptPasswordHasher: scrypt$<n>$<salt>$<r>$<p>$<hash>
+		prefixes: []string{"scrypt$"},
+		check: func(encoded string) error {
+			_, err := parseScrypt(encoded)
+			return err
+		},
+		verify: func(password, encoded string) bool {
+			params, err := parseScrypt(encoded)
+			if err != nil {
+				return false
+			}
+			computed, err := scrypt.Key([]byte(password), []byte(params.salt), params.n, params.r, params.p, len(params.key))
+			if err != nil {
+				return false
+			}
+			return subtle.ConstantTimeCompare(computed, params.key) == 1
+		},
+	},
+}
+
+// importedFormat returns the format of a hash imported from another system
+func importedFormat(encoded string) (hashFormat, bool) {
+	for _, format := range importedFormats {
+		for _, prefix := range format.prefixes {
+			if strings.HasPrefix(encoded, prefix) {
+				return format, true
+			}
+		}
+	}
+	return hashFormat{}, false
+}
+
+// CheckHash reports whether a password hash can be imported as is: an argon2id PHC
+// string, or a hash in one of the imported formats. The hash is parsed, not computed.
+func CheckHash(hash []byte) error {
+	encoded := string(hash)
+	if strings.HasPrefix(encoded, argon2idPrefix) {
+		if _, _, _, err := parseHash(encoded); err != nil {
+			return ErrUnsupportedHash
+		}
+		return nil
+	}
+
+	format, ok := importedFormat(encoded)
+	if !ok || format.check(encoded) != nil {
+		return ErrUnsupportedHash
+	}
+	return nil
+}
+
+func parsePBKDF2(encoded string) (int, string, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return 0, "", nil, errMalformedHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, "", nil, errMalformedHash
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, "", nil, errMalformedHash
+	}
+	return iterations, parts[2], key, nil
+}
+
+type scryptParams struct {
+	salt    string
+	n, r, p int
+	key     []byte
+}
+
+func parseScrypt(encoded string) (scryptParams, error) {
+	var params scryptParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, errMalformedHash
+	}
+	// n comes before the salt, r and p after it
+	params.salt = parts[2]
+	for value, field := range map[*int]string{&params.n: parts[1], &params.r: parts[3], &params.p: parts[4]} {
+		number, err := strconv.Atoi(field)
+		if err != nil || number < 1 {
+			return params, errMalformedHash
+		}
+		*value = number
+	}
+	// N must be a power of two greater than 1
+	if params.n < 2 || params.n&(params.n-1) != 0 {
+		return params, errMalformedHash
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, errMalformedHash
+	}
+	params.key = key
+	return params, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes of "password" in the formats of the systems users are imported from. The bcrypt
+// one is the example of the PHP manual, the Django ones were computed with Python's
+// hashlib and encoded the way Django's hashers encode them.
+var importedHashes = map[string]string{
+	// PHP password_hash, of "rasmuslerdorf"
+	"bcrypt $2y$": "$2y$10$.vGA1O9wmRjrwAVXD98HNOgsNpDczlqm3Jq7KnEd1rVAGv3Fykk1a",
+	// Django, with the salt "seasalt"
+	"pbkdf2_sha256": "pbkdf2_sha256$1000$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+	"scrypt":        "scrypt$16384$seasalt$8$1$eOpDhRCfbI3NxvoutPzwTJByfunoEKxznRXxmX5Ksum81s9etqoI2OgT8XQu/ddounBI84dtgAldPeCV2t26vA==",
+}
+
+func TestVerifyPassword_Imported(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash with bcrypt: %v", err)
+	}
+	hashes := map[string]string{"bcrypt": string(bcryptHash)}
+	for name, hash := range importedHashes {
+		hashes[name] = hash
+	}
+
+	for name, hash := range hashes {
+		password := "password"
+		if strings.HasPrefix(hash, "$2y$") {
+			password = "rasmuslerdorf"
+		}
+		user := &User{Password: []byte(hash)}
+
+		if ok, rehash := verifyPassword(password, user, testParams); !ok || !rehash {
+			t.Errorf("%s: expected a match needing a rehash, got (%v, %v)", name, ok, rehash)
+		}
+		if ok, _ := verifyPassword("wrong", user, testParams); ok {
+			t.Errorf("%s: expected a wrong password to be rejected", name)
+		}
+	}
+}
+
+func TestCheckHash(t *testing.T) {
+	phc, _ := hashPassword("password", testParams)
+	valid := []string{string(phc)}
+	for _, hash := range importedHashes {
+		valid = append(valid, hash)
+	}
+	for _, hash := range valid {
+		if err := CheckHash([]byte(hash)); err != nil {
+			t.Errorf("%s: expected no error, got %v", hash, err)
+		}
+	}
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"md5$salt$hash",
+		"$2a$10$tooshort",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"pbkdf2_sha256$many$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+		"scrypt$1000$seasalt$8$1$eOpDhRCfbI3NxvoutPzwTJByfunoEKxznRXxmX5Ksum81s9etqoI2OgT8XQu/ddounBI84dtgAldPeCV2t26vA==",
+		"scrypt$seasalt$16384$8$1$eOpDhRCfbI3NxvoutPzwTJByfunoEKxznRXxmX5Ksum81s9etqoI2OgT8XQu/ddounBI84dtgAldPeCV2t26vA==",
+	} {
+		if err := CheckHash([]byte(hash)); err != ErrUnsupportedHash {
+			t.Errorf("%q: expected ErrUnsupportedHash, got %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aloysb/auth-session/autherr"
+)
+
+// Default limits of failed sign ins
+const (
+	DefaultMaxFailures   = 5
+	DefaultMaxIPFailures = 50
+	DefaultFailureWindow = 15 * time.Minute
+	DefaultLockout       = time.Minute
+	DefaultMaxLockout    = time.Hour
+)
+
+// Errors wrapped by LockoutError
+var (
+	ErrAccountTemporarilyLocked = autherr.ErrAccountTemporarilyLocked
+	ErrTooManyAttempts          = autherr.ErrTooManyAttempts
+)
+
+// LockoutError is returned when signing in is refused after too many failures:
+// ErrAccountTemporarilyLocked for the account, ErrTooManyAttempts for the client IP
+type LockoutError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockoutError) Unwrap() error {
+	return e.Err
+}
+
+// LockoutConfig limits the failed sign ins per account and per client IP. Reaching
+// the limit within the window locks the account or IP out, for a duration doubling
+// with each lockout until one window passes without failures.
+type LockoutConfig struct {
+	// MaxFailures per account, 0 disables the account limit
+	MaxFailures int
+	// MaxIPFailures per client IP whatever the account, 0 disables the IP limit
+	MaxIPFailures int
+	// Window is the period failures are counted over
+	Window time.Duration
+	// Lockout is the duration of the first lockout, MaxLockout caps the following ones
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+func DefaultLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		MaxFailures:   DefaultMaxFailures,
+		MaxIPFailures: DefaultMaxIPFailures,
+		Window:        DefaultFailureWindow,
+		Lockout:       DefaultLockout,
+		MaxLockout:    DefaultMaxLockout,
+	}
+}
+
+// LoginAttempts are the recent failed sign ins of an account or a client IP
+type LoginAttempts struct {
+	Id          string
+	Failures    int
+	WindowStart time.Time
+	// Lockouts counts the consecutive lockouts, each one lasting twice the previous
+	Lockouts    int
+	LockedUntil time.Time
+}
+
+// reserve counts an attempt before its password is checked, so that concurrent
+// attempts cannot all pass a check made before any of them failed: once the pending
+// and failed attempts reach max, the next ones are refused. It returns how long to
+// wait when the attempt is refused, 0 otherwise.
+func (c LockoutConfig) reserve(attempts *LoginAttempts, now time.Time, max int) time.Duration {
+	if attempts.LockedUntil.After(now) {
+		return attempts.LockedUntil.Sub(now)
+	}
+	if now.Sub(attempts.WindowStart) > c.Window {
+		// A window without lockout resets the backoff
+		if now.Sub(attempts.LockedUntil) > c.Window {
+			attempts.Lockouts = 0
+		}
+		attempts.Failures = 0
+		attempts.WindowStart = now
+	}
+
+	if attempts.Failures >= max {
+		// The pending attempts are about to lock out if they fail
+		return c.lockout(attempts.Lockouts + 1)
+	}
+	attempts.Failures++
+	return 0
+}
+
+// lockIfReached locks out once the failures of the window reach max
+func (c LockoutConfig) lockIfReached(attempts *LoginAttempts, now time.Time, max int) {
+	if attempts.Failures < max || attempts.LockedUntil.After(now) {
+		return
+	}
+	attempts.Lockouts++
+	attempts.LockedUntil = now.Add(c.lockout(attempts.Lockouts))
+	attempts.Failures = 0
+	attempts.WindowStart = now
+}
+
+// refund gives back an attempt reserved for a sign in that did not fail
+func refund(attempts *LoginAttempts) {
+	if attempts.Failures > 0 {
+		attempts.Failures--
+	}
+}
+
+// lockout returns the duration of the nth consecutive lockout
+func (c LockoutConfig) lockout(n int) time.Duration {
+	duration := c.Lockout
+	for i := 1; i < n && duration < c.MaxLockout; i++ {
+		duration *= 2
+	}
+	if c.MaxLockout > 0 && duration > c.MaxLockout {
+		return c.MaxLockout
+	}
+	return duration
+}
+
+// Ids of the attempts of an account and of a client IP
+func accountAttemptsId(email string) string { return "email:" + email }
+func ipAttemptsId(ip string) string         { return "ip:" + ip }
+
+// reserveAttempt counts the sign in against the IP and the account before the
+// password is checked, and refuses it while either is locked out.
+func (b *BasicAuthService) reserveAttempt(email, ip string, now time.Time) error {
+	config := b.config.Lockout
+	if config.MaxIPFailures > 0 && ip != "" {
+		if err := b.reserve(ipAttemptsId(ip), now, config.MaxIPFailures, ErrTooManyAttempts); err != nil {
+			return err
+		}
+	}
+	if config.MaxFailures > 0 {
+		if err := b.reserve(accountAttemptsId(email), now, config.MaxFailures, ErrAccountTemporarilyLocked); err != nil {
+			// The password is not checked, the IP gets its attempt back
+			if config.MaxIPFailures > 0 && ip != "" {
+				b.updateAttempts(ipAttemptsId(ip), refund)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// reserve counts an attempt in the same transaction as the lockout is checked
+func (b *BasicAuthService) reserve(id string, now time.Time, max int, lockedErr error) error {
+	config := b.config.Lockout
+	var retryAfter time.Duration
+	err := b.store.UpdateLoginAttempts(id, func(attempts *LoginAttempts) {
+		retryAfter = config.reserve(attempts, now, max)
+	})
+	if err != nil {
+		return fmt.Errorf("could not record login attempt: %w", err)
+	}
+	if retryAfter > 0 {
+		return &LockoutError{Err: lockedErr, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// recordFailure keeps the reserved attempts as failures, locking out the account or
+// the IP once they reach their limit. Emails without an account are counted as well,
+// they are locked out like the others.
+func (b *BasicAuthService) recordFailure(email, ip string, now time.Time) {
+	config := b.config.Lockout
+	if config.MaxFailures > 0 {
+		b.updateAttempts(accountAttemptsId(email), func(attempts *LoginAttempts) {
+			config.lockIfReached(attempts, now, config.MaxFailures)
+		})
+	}
+	if config.MaxIPFailures > 0 && ip != "" {
+		b.updateAttempts(ipAttemptsId(ip), func(attempts *LoginAttempts) {
+			config.lockIfReached(attempts, now, config.MaxIPFailures)
+		})
+	}
+}
+
+// releaseAttempt gives back the reserved attempts of a sign in that did not fail.
+// After a successful one the account starts over, the IP keeps its failures.
+func (b *BasicAuthService) releaseAttempt(email, ip string, succeeded bool) {
+	config := b.config.Lockout
+	if config.MaxIPFailures > 0 && ip != "" {
+		b.updateAttempts(ipAttemptsId(ip), refund)
+	}
+	switch {
+	case config.MaxFailures <= 0:
+	case succeeded:
+		if err := b.store.DeleteLoginAttempts(accountAttemptsId(email)); err != nil {
+			slog.Error("could not reset login attempts", "email", email, "error", err)
+		}
+	default:
+		b.updateAttempts(accountAttemptsId(email), refund)
+	}
+}
+
+// updateAttempts updates the attempts after the password was checked, errors are
+// only logged as the sign in has its outcome already
+func (b *BasicAuthService) updateAttempts(id string, update func(*LoginAttempts)) {
+	if err := b.store.UpdateLoginAttempts(id, update); err != nil {
+		slog.Error("could not record login attempt", "id", id, "error", err)
+	}
+}
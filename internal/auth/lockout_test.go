@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func setupLockoutService(config LockoutConfig) *BasicAuthService {
+	authConfig := DefaultConfig()
+	authConfig.Argon2 = testParams
+	authConfig.Lockout = config
+	return New(NewMemoryStore(nil), authConfig)
+}
+
+func TestLockoutConfig_Backoff(t *testing.T) {
+	config := LockoutConfig{Window: 15 * time.Minute, Lockout: time.Minute, MaxLockout: 5 * time.Minute}
+	for n, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 5 * time.Minute, 60: 5 * time.Minute} {
+		if got := config.lockout(n); got != want {
+			t.Errorf("lockout %d: got %v, want %v", n, got, want)
+		}
+	}
+
+	now := time.Now()
+	attempts := &LoginAttempts{}
+	fail := func() {
+		config.reserve(attempts, now, 3)
+		config.lockIfReached(attempts, now, 3)
+	}
+	for i := 0; i < 3; i++ {
+		fail()
+	}
+	if attempts.Lockouts != 1 || !attempts.LockedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected a first lockout of a minute, got %+v", attempts)
+	}
+
+	// Failing again right after the lockout doubles the next one
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		fail()
+	}
+	if attempts.Lockouts != 2 || !attempts.LockedUntil.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("expected a second lockout of two minutes, got %+v", attempts)
+	}
+
+	// A quiet window starts over
+	now = now.Add(time.Hour)
+	fail()
+	if attempts.Lockouts != 0 || attempts.Failures != 1 {
+		t.Errorf("expected the backoff to be reset, got %+v", attempts)
+	}
+}
+
+func TestSignIn_AccountLockout(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxFailures: 3, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.SignIn("test@user.com", "wrongpassword", "192.0.2.1"); err != ErrInvalidCredentials {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i, err)
+		}
+	}
+
+	// The right password is refused as well, from any IP
+	err := s.SignIn("test@user.com", "testpassword", "192.0.2.2")
+	var lockoutErr *LockoutError
+	if !errors.As(err, &lockoutErr) || !errors.Is(err, ErrAccountTemporarilyLocked) {
+		t.Fatalf("expected ErrAccountTemporarilyLocked, got %v", err)
+	}
+	if lockoutErr.RetryAfter <= 0 || lockoutErr.RetryAfter > time.Minute {
+		t.Errorf("unexpected retry after %v", lockoutErr.RetryAfter)
+	}
+
+	if err := s.UnlockUser("test@user.com"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", "192.0.2.1"); err != nil {
+		t.Errorf("expected no error after unlock, got %v", err)
+	}
+}
+
+func TestSignIn_ConcurrentAttempts(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxFailures: 3, MaxIPFailures: 5, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// A burst checks no more passwords than allowed, whatever the interleaving
+	errs := make(chan error, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.SignIn("test@user.com", "wrongpassword", "192.0.2.1")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	checked := 0
+	for err := range errs {
+		var lockoutErr *LockoutError
+		switch {
+		case err == ErrInvalidCredentials:
+			checked++
+		case !errors.As(err, &lockoutErr):
+			t.Errorf("expected a LockoutError, got %v", err)
+		}
+	}
+	if checked == 0 || checked > 3 {
+		t.Errorf("expected at most 3 passwords to be checked, got %d", checked)
+	}
+}
+
+func TestSignIn_RefusedAttemptsAreNotCounted(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxFailures: 2, MaxIPFailures: 3, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.SignIn("test@user.com", "wrongpassword", "192.0.2.1")
+	}
+	// Refused by the account lockout, the IP is not charged for it
+	if err := s.SignIn("test@user.com", "wrongpassword", "192.0.2.1"); !errors.Is(err, ErrAccountTemporarilyLocked) {
+		t.Fatalf("expected ErrAccountTemporarilyLocked, got %v", err)
+	}
+	if err := s.SignUp("other@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.SignIn("other@user.com", "testpassword", "192.0.2.1"); err != nil {
+		t.Errorf("expected the IP to sign in another account, got %v", err)
+	}
+}
+
+func TestSignIn_SuccessResetsAccount(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, password := range []string{"wrongpassword", "testpassword", "wrongpassword"} {
+		s.SignIn("test@user.com", password, "")
+	}
+	if err := s.SignIn("test@user.com", "testpassword", ""); err != nil {
+		t.Errorf("expected the failures to start over after a sign in, got %v", err)
+	}
+}
+
+func TestSignIn_IPLockout(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxIPFailures: 2, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Failures against different accounts, known or not, add up
+	s.SignIn("test@user.com", "wrongpassword", "192.0.2.1")
+	s.SignIn("unknown@user.com", "wrongpassword", "192.0.2.1")
+
+	if err := s.SignIn("test@user.com", "testpassword", "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected ErrTooManyAttempts, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "testpassword", "192.0.2.2"); err != nil {
+		t.Errorf("expected other IPs to sign in, got %v", err)
+	}
+}
+
+func TestChangePassword_Lockout(t *testing.T) {
+	s := setupLockoutService(LockoutConfig{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute})
+	if err := s.SignUp("test@user.com", "testpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.ChangePassword("test@user.com", "wrongpassword", "newpassword", "current")
+	}
+	if err := s.ChangePassword("test@user.com", "testpassword", "newpassword", "current"); !errors.Is(err, ErrAccountTemporarilyLocked) {
+		t.Errorf("expected ErrAccountTemporarilyLocked, got %v", err)
+	}
+}
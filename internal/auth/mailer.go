@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/url"
+)
+
+// Mailer sends the emails of hardened sign ups
+type Mailer interface {
+	// SendVerification sends the token confirming the email
+	SendVerification(email, token string) error
+	// SendAccountExists tells the owner of the email that someone signed up with it again
+	SendAccountExists(email string) error
+}
+
+// LogMailer logs the emails instead of sending them, for development
+type LogMailer struct{}
+
+func (LogMailer) SendVerification(email, token string) error {
+	slog.Info("email verification", "email", email, "token", token)
+	return nil
+}
+
+func (LogMailer) SendAccountExists(email string) error {
+	slog.Info("sign up with an existing account", "email", email)
+	return nil
+}
+
+// SMTPMailer sends plain text emails through an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	// Username and Password authenticate with PLAIN auth when set
+	Username string
+	Password string
+	From     string
+	// VerifyURL is the page receiving the token as its token query parameter
+	VerifyURL string
+}
+
+func (m *SMTPMailer) SendVerification(email, token string) error {
+	link, err := url.Parse(m.VerifyURL)
+	if err != nil {
+		return fmt.Errorf("invalid verification url: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return m.send(email, "Verify your email",
+		"Follow this link to verify your email and finish signing up:\r\n\r\n"+link.String()+"\r\n\r\n"+
+			"If you did not sign up, you can ignore this email.\r\n")
+}
+
+func (m *SMTPMailer) SendAccountExists(email string) error {
+	return m.send(email, "You already have an account",
+		"Someone tried to sign up with this email, which already has an account.\r\n\r\n"+
+			"If it was you, sign in instead. Otherwise, you can ignore this email.\r\n")
+}
+
+func (m *SMTPMailer) send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	message := "From: " + m.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(message))
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// MemoryStore is a concurrency-safe UserStore keeping users in process memory
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[string]User
+	verifications map[string]Verification
+	attempts      map[string]LoginAttempts
+	nextId        int
+	sessions      SessionRevoker
+}
+
+// NewMemoryStore creates an empty store. Sessions are revoked through the given
+// revoker on password change and lock, it may be nil when there are none to revoke.
+func NewMemoryStore(sessions SessionRevoker) *MemoryStore {
+	return &MemoryStore{
+		users:         make(map[string]User),
+		verifications: make(map[string]Verification),
+		attempts:      make(map[string]LoginAttempts),
+		sessions:      sessions,
+	}
+}
+
+func (m *MemoryStore) GetByEmail(email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[email]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (m *MemoryStore) Create(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.Email]; ok {
+		return ErrUserAlreadyExists
+	}
+
+	m.nextId++
+	stored := *user
+	stored.Id = strconv.Itoa(m.nextId)
+	m.users[user.Email] = stored
+	return nil
+}
+
+func (m *MemoryStore) Import(users []*User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	imported := 0
+	for _, user := range users {
+		if _, ok := m.users[user.Email]; ok {
+			continue
+		}
+		m.nextId++
+		stored := *user
+		stored.Id = strconv.Itoa(m.nextId)
+		m.users[user.Email] = stored
+		imported++
+	}
+	return imported, nil
+}
+
+func (m *MemoryStore) ChangePassword(email string, password []byte, keepSessionId session.SessionID) error {
+	return m.updateAndRevoke(email, keepSessionId, true, func(user *User) {
+		user.Password = password
+		user.Salt = nil
+	})
+}
+
+func (m *MemoryStore) UpdatePasswordHash(email string, oldPassword, newPassword []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[email]
+	if !ok || !bytes.Equal(user.Password, oldPassword) {
+		return nil
+	}
+	user.Password = newPassword
+	user.Salt = nil
+	m.users[email] = user
+	return nil
+}
+
+func (m *MemoryStore) CreateVerification(verification *Verification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verifications[verification.Id] = *verification
+	return nil
+}
+
+func (m *MemoryStore) VerifyEmail(id string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	verification, ok := m.verifications[id]
+	if !ok || verification.ExpiresAt.Before(now) {
+		return ErrInvalidVerification
+	}
+	for id, other := range m.verifications {
+		if other.Email == verification.Email {
+			delete(m.verifications, id)
+		}
+	}
+
+	if _, ok := m.users[verification.Email]; ok {
+		return ErrInvalidVerification
+	}
+	m.nextId++
+	m.users[verification.Email] = User{
+		Id:       strconv.Itoa(m.nextId),
+		Email:    verification.Email,
+		Password: verification.Password,
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpiredVerifications(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, verification := range m.verifications {
+		if verification.ExpiresAt.Before(before) {
+			delete(m.verifications, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemoryStore) GetLoginAttempts(id string) (*LoginAttempts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attempts, ok := m.attempts[id]
+	if !ok {
+		return &LoginAttempts{Id: id}, nil
+	}
+	return &attempts, nil
+}
+
+func (m *MemoryStore) UpdateLoginAttempts(id string, update func(attempts *LoginAttempts)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[id]
+	if !ok {
+		attempts = LoginAttempts{Id: id}
+	}
+	update(&attempts)
+	m.attempts[id] = attempts
+	return nil
+}
+
+func (m *MemoryStore) DeleteLoginAttempts(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpiredLoginAttempts(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, attempts := range m.attempts {
+		if attempts.WindowStart.Before(before) && attempts.LockedUntil.Before(before) {
+			delete(m.attempts, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStore) SetLocked(email string, locked bool) error {
+	return m.updateAndRevoke(email, "", locked, func(user *User) {
+		user.Locked = locked
+	})
+}
+
+// updateAndRevoke applies an update to a user and optionally revokes their sessions.
+// The user lock is held throughout, so a sign in cannot see the update without the revocation.
+func (m *MemoryStore) updateAndRevoke(email string, keepSessionId session.SessionID, revoke bool, update func(user *User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[email]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	if revoke && m.sessions != nil {
+		if _, err := m.sessions.DeleteByUser(email, keepSessionId); err != nil {
+			return fmt.Errorf("could not revoke sessions: %w", err)
+		}
+	}
+
+	update(&user)
+	m.users[email] = user
+	return nil
+}
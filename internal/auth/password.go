@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Parameters of the hashes stored before PHC strings, with a separate salt column
+const (
+	legacyTimeCost    = 1         // Time cost parameter
+	legacyMemoryCost  = 64 * 1024 // Memory cost parameter (64 MB)
+	legacyParallelism = 4         // Number of parallel threads
+	legacyKeyLength   = 32        // Length of the key
+)
+
+// Prefix of the PHC strings of argon2id hashes
+const argon2idPrefix = "$argon2id$"
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// Argon2Params are the argon2id cost parameters of new password hashes.
+// Each hash records its own parameters, so they can be raised at any time:
+// older hashes are upgraded on the next sign in.
+type Argon2Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params returns the parameters the legacy hashes were computed with
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      legacyMemoryCost,
+		Iterations:  legacyTimeCost,
+		Parallelism: legacyParallelism,
+		SaltLength:  16,
+		KeyLength:   legacyKeyLength,
+	}
+}
+
+// Validate checks the parameters against the limits of argon2
+func (p Argon2Params) Validate() error {
+	if p.Iterations < 1 {
+		return errors.New("argon2 iterations must be at least 1")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("argon2 parallelism must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return errors.New("argon2 memory must be at least 8 KiB per thread")
+	}
+	if p.SaltLength < 8 {
+		return errors.New("argon2 salt must be at least 8 bytes")
+	}
+	if p.KeyLength < 16 {
+		return errors.New("argon2 key must be at least 16 bytes")
+	}
+	return nil
+}
+
+// hashPassword hashes the password with a random salt and encodes it as a PHC string:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+func hashPassword(password string, params Argon2Params) ([]byte, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+// parseHash reads the parameters, salt and key of a PHC string
+func parseHash(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return params, nil, nil, errMalformedHash
+	}
+
+	parts := strings.Split(strings.TrimPrefix(encoded, argon2idPrefix), "$")
+	if len(parts) != 4 {
+		return params, nil, nil, errMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if params.Validate() != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	return params, salt, key, nil
+}
+
+// verifyPassword checks the password against the stored hash of a user: a PHC string,
+// a hash imported from another system, or a legacy hash with its salt. It also reports
+// whether the hash should be recomputed with the current parameters.
+func verifyPassword(password string, user *User, current Argon2Params) (ok, rehash bool) {
+	// Legacy hashes have a separate salt and the default parameters
+	if len(user.Salt) > 0 {
+		key := argon2.IDKey([]byte(password), user.Salt, legacyTimeCost, legacyMemoryCost, legacyParallelism, legacyKeyLength)
+		return subtle.ConstantTimeCompare(key, user.Password) == 1, true
+	}
+
+	// Hashes imported from other systems are upgraded to argon2id
+	encoded := string(user.Password)
+	if format, ok := importedFormat(encoded); ok {
+		return format.verify(password, encoded), true
+	}
+
+	params, salt, key, err := parseHash(encoded)
+	if err != nil {
+		return false, false
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false
+	}
+	return true, params != current
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Cheap parameters, to keep the tests fast
+var testParams = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashPassword_PHC(t *testing.T) {
+	hash, err := hashPassword("password", testParams)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected PHC string %s", hash)
+	}
+
+	params, salt, key, err := parseHash(string(hash))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if params != testParams || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("unexpected parse result %+v", params)
+	}
+
+	other, _ := hashPassword("password", testParams)
+	if string(other) == string(hash) {
+		t.Errorf("expected every hash to have its own salt")
+	}
+}
+
+func TestParseHash_Malformed(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64,t=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+	} {
+		if _, _, _, err := parseHash(encoded); err != errMalformedHash {
+			t.Errorf("%q: expected errMalformedHash, got %v", encoded, err)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, _ := hashPassword("password", testParams)
+	user := &User{Password: hash}
+
+	if ok, rehash := verifyPassword("password", user, testParams); !ok || rehash {
+		t.Errorf("expected a match without rehash, got (%v, %v)", ok, rehash)
+	}
+	if ok, _ := verifyPassword("wrong", user, testParams); ok {
+		t.Errorf("expected a wrong password to be rejected")
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+	if ok, rehash := verifyPassword("password", user, stronger); !ok || !rehash {
+		t.Errorf("expected a match needing a rehash, got (%v, %v)", ok, rehash)
+	}
+
+	if ok, _ := verifyPassword("password", &User{Password: []byte("garbage")}, testParams); ok {
+		t.Errorf("expected a malformed hash to be rejected")
+	}
+}
+
+func TestVerifyPassword_Legacy(t *testing.T) {
+	salt := []byte("legacysalt")
+	key := argon2.IDKey([]byte("password"), salt, legacyTimeCost, legacyMemoryCost, legacyParallelism, legacyKeyLength)
+	user := &User{Password: key, Salt: salt}
+
+	if ok, rehash := verifyPassword("password", user, DefaultArgon2Params()); !ok || !rehash {
+		t.Errorf("expected a legacy match needing a rehash, got (%v, %v)", ok, rehash)
+	}
+	if ok, _ := verifyPassword("wrong", user, DefaultArgon2Params()); ok {
+		t.Errorf("expected a wrong password to be rejected")
+	}
+}
+
+func TestArgon2Params_Validate(t *testing.T) {
+	if err := DefaultArgon2Params().Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %v", err)
+	}
+
+	for name, params := range map[string]Argon2Params{
+		"iterations":  {Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"parallelism": {Memory: 64, Iterations: 1, Parallelism: 0, SaltLength: 16, KeyLength: 32},
+		"memory":      {Memory: 8, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		"salt":        {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 4, KeyLength: 32},
+		"key":         {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 8},
+	} {
+		if err := params.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
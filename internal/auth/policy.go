@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aloysb/auth-session/autherr"
+)
+
+// Default length limits of passwords, in characters
+const (
+	DefaultMinPasswordLength = 8
+	DefaultMaxPasswordLength = 128
+)
+
+// ErrWeakPassword matches every PolicyError
+var ErrWeakPassword = autherr.ErrWeakPassword
+
+// Rules of the password policy, reported by PolicyError
+const (
+	ViolationTooShort      = autherr.ViolationTooShort
+	ViolationTooLong       = autherr.ViolationTooLong
+	ViolationContainsEmail = autherr.ViolationContainsEmail
+	ViolationBreached      = autherr.ViolationBreached
+)
+
+// PolicyError lists every rule of the policy a password breaks, so that they can
+// all be shown at once
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrWeakPassword, strings.Join(e.Violations, ", "))
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// PasswordPolicy are the rules new passwords must follow, on sign up and password change
+type PasswordPolicy struct {
+	// MinLength and MaxLength bound the number of characters, 0 disables the bound
+	MinLength int
+	MaxLength int
+	// Breached rejects the passwords it lists when set
+	Breached *BreachedList
+}
+
+// DefaultPasswordPolicy bounds the length only, there is no default breached list
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: DefaultMinPasswordLength,
+		MaxLength: DefaultMaxPasswordLength,
+	}
+}
+
+// Check returns a PolicyError listing the rules the password breaks, if any.
+// The password never contains the email of its user.
+func (p PasswordPolicy) Check(email, password string) error {
+	var violations []string
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, ViolationTooShort)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, ViolationTooLong)
+	}
+	if email != "" && strings.Contains(strings.ToLower(password), strings.ToLower(email)) {
+		violations = append(violations, ViolationContainsEmail)
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, ViolationBreached)
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// BreachedList holds the SHA-1 hashes of breached passwords, indexed like the
+// k-anonymity range API of Have I Been Pwned: by the first 5 hex characters of
+// the hash, each prefix listing the remaining 35 characters.
+type BreachedList struct {
+	ranges map[string][]string
+}
+
+// LoadBreachedList reads a file of the Pwned Passwords downloader, one uppercase
+// hash per line followed by its count: <40 hex characters>:<count>.
+// Lines with a count of 0 are padding and skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached passwords: %w", err)
+	}
+	defer file.Close()
+
+	list := &BreachedList{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hash, count, found := strings.Cut(text, ":")
+		if found {
+			if n, err := strconv.Atoi(count); err != nil {
+				return nil, fmt.Errorf("breached passwords line %d: invalid count %q", line, count)
+			} else if n == 0 {
+				continue
+			}
+		}
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("breached passwords line %d: invalid SHA-1 hash %q", line, hash)
+		}
+		list.ranges[hash[:5]] = append(list.ranges[hash[:5]], hash[5:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read breached passwords: %w", err)
+	}
+
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+	return list, nil
+}
+
+// Contains reports whether the password is in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := l.ranges[hash[:5]]
+	i := sort.SearchStrings(suffixes, hash[5:])
+	return i < len(suffixes) && suffixes[i] == hash[5:]
+}
+
+// Len returns the number of hashes in the list
+func (l *BreachedList) Len() int {
+	count := 0
+	for _, suffixes := range l.ranges {
+		count += len(suffixes)
+	}
+	return count
+}
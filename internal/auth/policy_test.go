@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16}
+
+	for password, want := range map[string][]string{
+		"correct horse":              nil,
+		"短いパスワードです":                  nil,
+		"short":                      {ViolationTooShort},
+		"much too long a passphrase": {ViolationTooLong},
+		"USER@EXAMPLE.COM":           {ViolationContainsEmail},
+		"x@user@example.com!!!!!!!!": {ViolationTooLong, ViolationContainsEmail},
+	} {
+		err := policy.Check("user@example.com", password)
+		if want == nil {
+			if err != nil {
+				t.Errorf("%q: expected no error, got %v", password, err)
+			}
+			continue
+		}
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) || !errors.Is(err, ErrWeakPassword) {
+			t.Fatalf("%q: expected a PolicyError, got %v", password, err)
+		}
+		if !reflect.DeepEqual(policyErr.Violations, want) {
+			t.Errorf("%q: got violations %v, want %v", password, policyErr.Violations, want)
+		}
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	// SHA-1 of "password" and "123456", and a padding line
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	content := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n" +
+		"7c4a8d09ca3762af61e59520943dc26494f8941b:37359195\n" +
+		"\n" +
+		"0A8C6B9B6C2E7C5A0E1C1C8F6C3C6B4A7C7C9A1E:0\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if list.Len() != 2 {
+		t.Errorf("expected 2 hashes, got %d", list.Len())
+	}
+	if !list.Contains("password") || !list.Contains("123456") {
+		t.Errorf("expected the breached passwords to be found")
+	}
+	if list.Contains("correct horse battery staple") {
+		t.Errorf("expected other passwords not to be found")
+	}
+
+	err = PasswordPolicy{Breached: list}.Check("user@example.com", "password")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || len(policyErr.Violations) != 1 || policyErr.Violations[0] != ViolationBreached {
+		t.Errorf("expected the breached violation, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not a hash:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(path); err == nil {
+		t.Errorf("expected an invalid line to be rejected")
+	}
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/utils"
+	"github.com/aloysb/auth-session/session"
+)
+
+// SQLStore is a UserStore backed by the users table. It shares its database
+// with the sessions table, so session revocation happens in the same transaction.
+type SQLStore struct {
+	db utils.UTCDB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{utils.UTCDB{DB: db}}
+}
+
+func (s *SQLStore) GetByEmail(email string) (*User, error) {
+	row := s.db.QueryRow("SELECT id, email, password, salt, locked FROM users WHERE email = $1", email)
+
+	var user User
+	var id sql.NullString
+	err := row.Scan(&id, &user.Email, &user.Password, &user.Salt, &user.Locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("could not query user: %w", err)
+	}
+	user.Id = id.String
+	return &user, nil
+}
+
+func (s *SQLStore) Create(user *User) error {
+	_, err := s.db.Exec("INSERT INTO users (email, password, salt) VALUES ($1, $2, $3)", user.Email, user.Password, legacySalt(user.Salt))
+	if err != nil {
+		return fmt.Errorf("could not insert user: %w", err)
+	}
+	return nil
+}
+
+// Import inserts the users in a single transaction
+func (s *SQLStore) Import(users []*User) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO users (email, password, salt, locked) VALUES ($1, $2, $3, $4) ON CONFLICT (email) DO NOTHING")
+	if err != nil {
+		return 0, fmt.Errorf("could not prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	imported := 0
+	for _, user := range users {
+		result, err := stmt.Exec(user.Email, user.Password, legacySalt(user.Salt), user.Locked)
+		if err != nil {
+			return 0, fmt.Errorf("could not insert user %s: %w", user.Email, err)
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("could not count inserted users: %w", err)
+		}
+		imported += int(inserted)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit import: %w", err)
+	}
+	return imported, nil
+}
+
+func (s *SQLStore) ChangePassword(email string, password []byte, keepSessionId session.SessionID) error {
+	return s.updateAndRevoke(email, keepSessionId, "UPDATE users SET password = $1, salt = $2 WHERE email = $3", password, legacySalt(nil), email)
+}
+
+// UpdatePasswordHash only replaces the hash it was computed from, a concurrent
+// password change wins over the rehash
+func (s *SQLStore) UpdatePasswordHash(email string, oldPassword, newPassword []byte) error {
+	_, err := s.db.Exec("UPDATE users SET password = $1, salt = $2 WHERE email = $3 AND password = $4", newPassword, legacySalt(nil), email, oldPassword)
+	if err != nil {
+		return fmt.Errorf("could not update password hash: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) SetLocked(email string, locked bool) error {
+	if !locked {
+		return s.update("UPDATE users SET locked = $1 WHERE email = $2", false, email)
+	}
+	return s.updateAndRevoke(email, "", "UPDATE users SET locked = $1 WHERE email = $2", true, email)
+}
+
+func (s *SQLStore) CreateVerification(verification *Verification) error {
+	_, err := s.db.Exec("INSERT INTO email_verifications (id, email, password, expires_at) VALUES ($1, $2, $3, $4)",
+		verification.Id, verification.Email, verification.Password, verification.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not insert verification: %w", err)
+	}
+	return nil
+}
+
+// VerifyEmail deletes the verifications of the email and inserts the user in one
+// transaction, so that only one of them is ever used
+func (s *SQLStore) VerifyEmail(id string, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	var password []byte
+	row := tx.QueryRow("DELETE FROM email_verifications WHERE id = $1 AND expires_at >= $2 RETURNING email, password", id, now)
+	if err := row.Scan(&email, &password); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidVerification
+		}
+		return fmt.Errorf("could not consume verification: %w", err)
+	}
+
+	// The other links of the sign ups of the email are spent as well
+	if _, err := tx.Exec("DELETE FROM email_verifications WHERE email = $1", email); err != nil {
+		return fmt.Errorf("could not delete verifications: %w", err)
+	}
+	result, err := tx.Exec("INSERT INTO users (email, password, salt) VALUES ($1, $2, $3) ON CONFLICT (email) DO NOTHING",
+		email, password, legacySalt(nil))
+	if err != nil {
+		return fmt.Errorf("could not insert user: %w", err)
+	}
+	// The email got an account in the meantime, e.g. by an import, which is kept
+	if err := userUpdated(result); err != nil {
+		return ErrInvalidVerification
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteExpiredVerifications(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM email_verifications WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired verifications: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted verifications: %w", err)
+	}
+	return int(removed), nil
+}
+
+func (s *SQLStore) GetLoginAttempts(id string) (*LoginAttempts, error) {
+	return getLoginAttempts(s.db, id)
+}
+
+// getLoginAttempts reads the attempts from the database or a transaction
+func getLoginAttempts(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, id string) (*LoginAttempts, error) {
+	attempts := &LoginAttempts{Id: id}
+	var lockedUntil sql.NullTime
+	row := q.QueryRow("SELECT failures, window_start, lockouts, locked_until FROM login_attempts WHERE id = $1", id)
+	err := row.Scan(&attempts.Failures, &attempts.WindowStart, &attempts.Lockouts, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return attempts, nil
+		}
+		return nil, fmt.Errorf("could not query login attempts: %w", err)
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
+
+// UpdateLoginAttempts reads and writes the attempts in a transaction holding the
+// lock on their row, so that concurrent failures are all counted
+func (s *SQLStore) UpdateLoginAttempts(id string, update func(attempts *LoginAttempts)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Writing the row first takes its lock before it is read
+	_, err = tx.Exec("INSERT INTO login_attempts (id, failures, window_start, lockouts) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO UPDATE SET failures = login_attempts.failures",
+		id, 0, time.Time{}, 0)
+	if err != nil {
+		return fmt.Errorf("could not lock login attempts: %w", err)
+	}
+	attempts, err := getLoginAttempts(tx, id)
+	if err != nil {
+		return err
+	}
+
+	update(attempts)
+	var lockedUntil sql.NullTime
+	if !attempts.LockedUntil.IsZero() {
+		lockedUntil = sql.NullTime{Time: attempts.LockedUntil, Valid: true}
+	}
+	_, err = tx.Exec("UPDATE login_attempts SET failures = $1, window_start = $2, lockouts = $3, locked_until = $4 WHERE id = $5",
+		attempts.Failures, attempts.WindowStart, attempts.Lockouts, lockedUntil, id)
+	if err != nil {
+		return fmt.Errorf("could not update login attempts: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteLoginAttempts(id string) error {
+	if _, err := s.db.Exec("DELETE FROM login_attempts WHERE id = $1", id); err != nil {
+		return fmt.Errorf("could not delete login attempts: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteExpiredLoginAttempts(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM login_attempts WHERE window_start < $1 AND (locked_until IS NULL OR locked_until < $1)", before)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired login attempts: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted login attempts: %w", err)
+	}
+	return int(deleted), nil
+}
+
+func (s *SQLStore) update(query string, args ...any) error {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("could not update user: %w", err)
+	}
+	return userUpdated(result)
+}
+
+// updateAndRevoke runs an update on a user and revokes their sessions atomically
+func (s *SQLStore) updateAndRevoke(email string, keepSessionId session.SessionID, query string, args ...any) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("could not update user: %w", err)
+	}
+	if err := userUpdated(result); err != nil {
+		return err
+	}
+
+	// Sessions are owned by the user's email
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", email, keepSessionId); err != nil {
+		return fmt.Errorf("could not revoke sessions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// legacySalt returns the value of the salt column, which is only filled for legacy
+// hashes but cannot be NULL
+func legacySalt(salt []byte) []byte {
+	if salt == nil {
+		return []byte{}
+	}
+	return salt
+}
+
+func userUpdated(result sql.Result) error {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not count updated users: %w", err)
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// UserStore persists users, looked up by email
+type UserStore interface {
+	// GetByEmail returns the user with the given email, or ErrUserNotFound
+	GetByEmail(email string) (*User, error)
+	// Create saves a new user
+	Create(user *User) error
+	// ChangePassword updates the password hash of a user and, in the same transaction,
+	// revokes all of their sessions except the one with the given id
+	ChangePassword(email string, password []byte, keepSessionId session.SessionID) error
+	// UpdatePasswordHash replaces the password hash of a user with an equivalent one,
+	// unless the password changed since oldPassword was read. Sessions are kept.
+	UpdatePasswordHash(email string, oldPassword, newPassword []byte) error
+	// SetLocked locks or unlocks a user, locking also revokes all of their sessions
+	SetLocked(email string, locked bool) error
+	// Import saves users in bulk, skipping those whose email already exists,
+	// and returns how many were saved
+	Import(users []*User) (int, error)
+	// CreateVerification saves a pending email verification
+	CreateVerification(verification *Verification) error
+	// VerifyEmail consumes the verification with the given id if it has not expired,
+	// along with the other verifications of its email, and creates the user with its
+	// password. It returns ErrInvalidVerification when there is no such verification,
+	// or when the email has an account already.
+	VerifyEmail(id string, now time.Time) error
+	// DeleteExpiredVerifications removes the verifications that expired before the given
+	// time and returns how many were removed
+	DeleteExpiredVerifications(before time.Time) (int, error)
+	// GetLoginAttempts returns the failed sign ins recorded under the id, none when
+	// nothing was recorded
+	GetLoginAttempts(id string) (*LoginAttempts, error)
+	// UpdateLoginAttempts applies the update to the attempts of the id and saves them.
+	// Concurrent updates of the same id, from any replica, are applied one after the other.
+	UpdateLoginAttempts(id string, update func(attempts *LoginAttempts)) error
+	// DeleteLoginAttempts forgets the failed sign ins recorded under the id
+	DeleteLoginAttempts(id string) error
+	// DeleteExpiredLoginAttempts removes the attempts whose window and lockout both
+	// ended before the given time
+	DeleteExpiredLoginAttempts(before time.Time) (int, error)
+}
+
+// SessionRevoker removes the sessions of a user, every session but exceptId.
+// It lets stores without a shared database revoke sessions on password change.
+type SessionRevoker interface {
+	DeleteByUser(userId string, exceptId session.SessionID) (int, error)
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/database/dbtest"
+	"github.com/aloysb/auth-session/session"
+)
+
+// storeFactories builds every UserStore implementation on a fresh backend,
+// along with the session store it revokes sessions from
+var storeFactories = map[string]func(t *testing.T) (UserStore, session.SessionStore){
+	"memory": func(t *testing.T) (UserStore, session.SessionStore) {
+		sessions := session.NewMemoryStore()
+		return NewMemoryStore(sessions), sessions
+	},
+	"sqlite": func(t *testing.T) (UserStore, session.SessionStore) {
+		db := dbtest.SQLite(t)
+		return NewSQLStore(db), session.NewSQLStore(db)
+	},
+}
+
+func TestStore_CreateAndGet(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore(t)
+
+			if _, err := store.GetByEmail("test@user.com"); err != ErrUserNotFound {
+				t.Fatalf("expected ErrUserNotFound, got %v", err)
+			}
+
+			user := &User{Email: "test@user.com", Password: []byte("hash"), Salt: []byte("salt")}
+			if err := store.Create(user); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			got, err := store.GetByEmail("test@user.com")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got.Id == "" {
+				t.Errorf("expected the store to assign an id")
+			}
+			if string(got.Password) != "hash" || string(got.Salt) != "salt" {
+				t.Errorf("unexpected user: %+v", got)
+			}
+		})
+	}
+}
+
+func TestStore_UpdatesRevokeSessions(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, sessions := newStore(t)
+
+			user := &User{Email: "test@user.com", Password: []byte("hash"), Salt: []byte("salt")}
+			if err := store.Create(user); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			now := time.Now()
+			for _, id := range []session.SessionID{"current", "other"} {
+				err := sessions.Create(&session.Session{Id: id, UserId: user.Email, ExpiresAt: now.Add(time.Hour), CreatedAt: now, LastSeenAt: now})
+				if err != nil {
+					t.Fatalf("failed to create session: %v", err)
+				}
+			}
+
+			if err := store.ChangePassword(user.Email, []byte("newhash"), "current"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, _ := store.GetByEmail(user.Email)
+			if string(got.Password) != "newhash" || len(got.Salt) != 0 {
+				t.Errorf("expected password to be updated, got %+v", got)
+			}
+			if _, err := sessions.Get("other"); err != session.ErrSessionNotFound {
+				t.Errorf("expected other sessions to be revoked, got %v", err)
+			}
+			if _, err := sessions.Get("current"); err != nil {
+				t.Errorf("expected the current session to be kept, got %v", err)
+			}
+
+			if err := store.SetLocked(user.Email, true); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, _ = store.GetByEmail(user.Email)
+			if !got.Locked {
+				t.Errorf("expected user to be locked")
+			}
+			if _, err := sessions.Get("current"); err != session.ErrSessionNotFound {
+				t.Errorf("expected every session to be revoked on lock, got %v", err)
+			}
+
+			if err := store.SetLocked("nonexistent@user.com", true); err != ErrUserNotFound {
+				t.Errorf("expected ErrUserNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_UpdatePasswordHash(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, sessions := newStore(t)
+
+			user := &User{Email: "test@user.com", Password: []byte("hash"), Salt: []byte("salt")}
+			if err := store.Create(user); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			now := time.Now()
+			err := sessions.Create(&session.Session{Id: "current", UserId: user.Email, ExpiresAt: now.Add(time.Hour), CreatedAt: now, LastSeenAt: now})
+			if err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+
+			if err := store.UpdatePasswordHash(user.Email, []byte("hash"), []byte("rehashed")); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, _ := store.GetByEmail(user.Email)
+			if string(got.Password) != "rehashed" || len(got.Salt) != 0 {
+				t.Errorf("expected the hash to be replaced, got %+v", got)
+			}
+			if _, err := sessions.Get("current"); err != nil {
+				t.Errorf("expected sessions to be kept, got %v", err)
+			}
+
+			// A rehash computed from a hash that changed since is dropped
+			if err := store.UpdatePasswordHash(user.Email, []byte("hash"), []byte("stale")); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, _ = store.GetByEmail(user.Email)
+			if string(got.Password) != "rehashed" {
+				t.Errorf("expected the stale rehash to be ignored, got %s", got.Password)
+			}
+		})
+	}
+}
+
+func TestStore_Import(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore(t)
+
+			if err := store.Create(&User{Email: "existing@user.com", Password: []byte("hash")}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			imported, err := store.Import([]*User{
+				{Email: "existing@user.com", Password: []byte("other")},
+				{Email: "new@user.com", Password: []byte("imported")},
+				{Email: "locked@user.com", Password: []byte("imported"), Locked: true},
+			})
+			if err != nil || imported != 2 {
+				t.Fatalf("Import: got (%d, %v), want (2, nil)", imported, err)
+			}
+
+			existing, _ := store.GetByEmail("existing@user.com")
+			if string(existing.Password) != "hash" {
+				t.Errorf("expected the existing user to be kept, got %s", existing.Password)
+			}
+			got, err := store.GetByEmail("new@user.com")
+			if err != nil || string(got.Password) != "imported" || got.Locked || got.Id == "" {
+				t.Errorf("unexpected imported user: %+v, %v", got, err)
+			}
+			if got, _ := store.GetByEmail("locked@user.com"); !got.Locked {
+				t.Errorf("expected the imported user to be locked")
+			}
+		})
+	}
+}
+
+func TestStore_Verifications(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore(t)
+
+			now := time.Now()
+			verifications := map[string]time.Time{"valid": now.Add(time.Hour), "other": now.Add(time.Hour), "expired": now.Add(-time.Hour)}
+			for id, expiresAt := range verifications {
+				err := store.CreateVerification(&Verification{Id: id, Email: "test@user.com", Password: []byte(id), ExpiresAt: expiresAt})
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			if err := store.VerifyEmail("expired", now); err != ErrInvalidVerification {
+				t.Errorf("expected ErrInvalidVerification, got %v", err)
+			}
+			if err := store.VerifyEmail("valid", now); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, err := store.GetByEmail("test@user.com")
+			if err != nil || string(got.Password) != "valid" {
+				t.Errorf("expected the user to be created with the password of the link, got %+v, %v", got, err)
+			}
+			if err := store.VerifyEmail("valid", now); err != ErrInvalidVerification {
+				t.Errorf("expected a used verification to be rejected, got %v", err)
+			}
+			if err := store.VerifyEmail("other", now); err != ErrInvalidVerification {
+				t.Errorf("expected the other verifications of the email to be spent, got %v", err)
+			}
+
+			// Expired verifications are swept, the others are kept
+			store.CreateVerification(&Verification{Id: "stale", Email: "new@user.com", Password: []byte("stale"), ExpiresAt: now.Add(-time.Hour)})
+			store.CreateVerification(&Verification{Id: "pending", Email: "new@user.com", Password: []byte("pending"), ExpiresAt: now.Add(time.Hour)})
+			if removed, err := store.DeleteExpiredVerifications(now); err != nil || removed != 1 {
+				t.Errorf("DeleteExpiredVerifications: got (%d, %v), want (1, nil)", removed, err)
+			}
+			if err := store.VerifyEmail("pending", now); err != nil {
+				t.Errorf("expected the pending verification to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_LoginAttempts(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore(t)
+
+			got, err := store.GetLoginAttempts("email:test@user.com")
+			if err != nil || got.Failures != 0 || !got.LockedUntil.IsZero() {
+				t.Fatalf("expected no attempts, got %+v, %v", got, err)
+			}
+
+			now := time.Now().Truncate(time.Second)
+			for i := 0; i < 2; i++ {
+				err := store.UpdateLoginAttempts("email:test@user.com", func(attempts *LoginAttempts) {
+					attempts.Failures++
+					attempts.WindowStart = now
+				})
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			err = store.UpdateLoginAttempts("ip:192.0.2.1", func(attempts *LoginAttempts) {
+				attempts.Lockouts = 1
+				attempts.WindowStart = now.Add(-time.Hour)
+				attempts.LockedUntil = now.Add(-time.Minute)
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			got, _ = store.GetLoginAttempts("email:test@user.com")
+			if got.Id != "email:test@user.com" || got.Failures != 2 || !got.WindowStart.Equal(now) || !got.LockedUntil.IsZero() {
+				t.Errorf("unexpected attempts %+v", got)
+			}
+			got, _ = store.GetLoginAttempts("ip:192.0.2.1")
+			if got.Lockouts != 1 || !got.LockedUntil.Equal(now.Add(-time.Minute)) {
+				t.Errorf("unexpected attempts %+v", got)
+			}
+
+			deleted, err := store.DeleteExpiredLoginAttempts(now.Add(-30 * time.Second))
+			if err != nil || deleted != 1 {
+				t.Errorf("DeleteExpiredLoginAttempts: got (%d, %v), want (1, nil)", deleted, err)
+			}
+			if err := store.DeleteLoginAttempts("email:test@user.com"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got, _ := store.GetLoginAttempts("email:test@user.com"); got.Failures != 0 {
+				t.Errorf("expected the attempts to be deleted, got %+v", got)
+			}
+		})
+	}
+}
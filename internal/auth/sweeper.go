@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// Sweeper periodically purges the records of the users store that are only kept for
+// a while: expired email verifications, and the login attempts whose failure window
+// and lockout are over. Several replicas may sweep the same store.
+type Sweeper struct {
+	store    UserStore
+	interval time.Duration
+	// window is the failure window of the lockout config, see LockoutConfig
+	window time.Duration
+}
+
+func NewSweeper(store UserStore, interval, window time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = session.DefaultSweepInterval
+	}
+	if window <= 0 {
+		window = DefaultFailureWindow
+	}
+	return &Sweeper{
+		store:    store,
+		interval: interval,
+		window:   window,
+	}
+}
+
+// Run sweeps on every interval until the context is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sweep(time.Now()); err != nil {
+				slog.Error("User store sweep failed", "error", err)
+			}
+		}
+	}
+}
+
+// Sweep deletes what expired before the given time
+func (s *Sweeper) Sweep(now time.Time) error {
+	removed, err := s.store.DeleteExpiredVerifications(now)
+	if err != nil {
+		return fmt.Errorf("could not sweep verifications: %w", err)
+	}
+	if removed > 0 {
+		slog.Info("Swept expired email verifications", "removed", removed)
+	}
+
+	removed, err = s.store.DeleteExpiredLoginAttempts(now.Add(-s.window))
+	if err != nil {
+		return fmt.Errorf("could not sweep login attempts: %w", err)
+	}
+	if removed > 0 {
+		slog.Info("Swept expired login attempts", "removed", removed)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweeper_Sweep(t *testing.T) {
+	store := NewMemoryStore(nil)
+	now := time.Now()
+	store.CreateVerification(&Verification{Id: "expired", Email: "test@user.com", Password: []byte("hash"), ExpiresAt: now.Add(-time.Hour)})
+	store.CreateVerification(&Verification{Id: "valid", Email: "other@user.com", Password: []byte("hash"), ExpiresAt: now.Add(time.Hour)})
+	store.UpdateLoginAttempts("ip:192.0.2.1", func(attempts *LoginAttempts) { attempts.WindowStart = now.Add(-time.Hour) })
+	store.UpdateLoginAttempts("ip:192.0.2.2", func(attempts *LoginAttempts) { attempts.WindowStart = now })
+
+	if err := NewSweeper(store, time.Minute, 15*time.Minute).Sweep(now); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := store.verifications["expired"]; ok {
+		t.Errorf("expected the expired verification to be removed")
+	}
+	if _, ok := store.verifications["valid"]; !ok {
+		t.Errorf("expected the valid verification to be kept")
+	}
+	if _, ok := store.attempts["ip:192.0.2.1"]; ok {
+		t.Errorf("expected the expired login attempts to be removed")
+	}
+	if _, ok := store.attempts["ip:192.0.2.2"]; !ok {
+		t.Errorf("expected the recent login attempts to be kept")
+	}
+}
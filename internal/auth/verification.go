@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aloysb/auth-session/autherr"
+	"github.com/aloysb/auth-session/internal/utils"
+)
+
+const DefaultVerificationLifetime = 24 * time.Hour
+
+// ErrInvalidVerification is returned for an unknown, expired or already used verification token
+var ErrInvalidVerification = autherr.ErrInvalidVerification
+
+// Verification is a pending confirmation of an email, identified by the SHA-256 hash
+// of the token sent to it. Password is the hash chosen at sign up, the user is only
+// created with it once the email is verified.
+type Verification struct {
+	Id        string
+	Email     string
+	Password  []byte
+	ExpiresAt time.Time
+}
+
+// signUpHardened answers every valid sign up the same way, whether or not the email
+// has an account, and lets the owner of the email know what happened:
+//   - an email without account gets a verification link, the user is only created
+//     when it is used, with the password of the sign up. Until then signing in fails
+//     like for any unknown email.
+//   - an email with an account is told so, its password is untouched
+func (b *BasicAuthService) signUpHardened(email, password string) error {
+	if !validEmail(email) {
+		return ErrInvalidEmail
+	}
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	if err := b.config.Policy.Check(email, password); err != nil {
+		return err
+	}
+
+	// Hash before looking the user up, every branch takes as long
+	hashedPassword, err := hashPassword(password, b.config.Argon2)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.store.GetByEmail(email)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+	case err != nil:
+		return fmt.Errorf("could not query user: %w", err)
+	default:
+		if err := b.config.Mailer.SendAccountExists(email); err != nil {
+			return fmt.Errorf("could not send email: %w", err)
+		}
+		return nil
+	}
+
+	token := utils.GenerateRandomString()
+	verification := &Verification{
+		Id:        verificationId(token),
+		Email:     email,
+		Password:  hashedPassword,
+		ExpiresAt: time.Now().Add(b.config.VerificationLifetime),
+	}
+	if err := b.store.CreateVerification(verification); err != nil {
+		return fmt.Errorf("could not create verification: %w", err)
+	}
+	if err := b.config.Mailer.SendVerification(email, token); err != nil {
+		return fmt.Errorf("could not send email: %w", err)
+	}
+	return nil
+}
+
+// VerifyEmail confirms the email the token was sent to and creates its user, who can
+// then sign in
+func (b *BasicAuthService) VerifyEmail(token string) error {
+	if token == "" {
+		return ErrInvalidVerification
+	}
+	return b.store.VerifyEmail(verificationId(token), time.Now())
+}
+
+// verificationId derives the stored id of a verification token
+func verificationId(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// dummyUser returns a user whose hash has the current parameters, checked against
+// when signing in with an unknown email so that it costs as much as a known one
+func (b *BasicAuthService) dummyUser() *User {
+	b.dummyOnce.Do(func() {
+		// Hashing only fails without randomness, sign ups would fail as well
+		hash, _ := hashPassword(utils.GenerateRandomString(), b.config.Argon2)
+		b.dummy = &User{Password: hash}
+	})
+	return b.dummy
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingMailer keeps the emails instead of sending them
+type recordingMailer struct {
+	tokens map[string]string
+	exists []string
+}
+
+func (m *recordingMailer) SendVerification(email, token string) error {
+	m.tokens[email] = token
+	return nil
+}
+
+func (m *recordingMailer) SendAccountExists(email string) error {
+	m.exists = append(m.exists, email)
+	return nil
+}
+
+func setupHardenedService() (*BasicAuthService, *recordingMailer) {
+	mailer := &recordingMailer{tokens: map[string]string{}}
+	config := DefaultConfig()
+	config.Argon2 = testParams
+	config.Hardened = true
+	config.Mailer = mailer
+	return New(NewMemoryStore(nil), config), mailer
+}
+
+func TestHardened_SignUp(t *testing.T) {
+	s, mailer := setupHardenedService()
+
+	if err := s.SignUp("invalidemail", "password"); err != ErrInvalidEmail {
+		t.Errorf("expected ErrInvalidEmail, got %v", err)
+	}
+
+	if err := s.SignUp("test@user.com", "password"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// The user only exists once verified, signing in fails like for an unknown email
+	if _, err := s.store.GetByEmail("test@user.com"); err != ErrUserNotFound {
+		t.Errorf("expected no user before verifying, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "password", ""); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	first := mailer.tokens["test@user.com"]
+	if err := s.VerifyEmail(first); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.VerifyEmail(first); err != ErrInvalidVerification {
+		t.Errorf("expected a used token to be rejected, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "password", ""); err != nil {
+		t.Errorf("expected the verified user to sign in, got %v", err)
+	}
+
+	// An existing account is not reported, its owner is told instead
+	if err := s.SignUp("test@user.com", "otherpassword"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if len(mailer.exists) != 1 || mailer.tokens["test@user.com"] != first {
+		t.Errorf("expected an account exists email only, got %v", mailer.exists)
+	}
+	if err := s.SignIn("test@user.com", "password", ""); err != nil {
+		t.Errorf("expected the password to be kept, got %v", err)
+	}
+}
+
+func TestHardened_SignUpAgainBeforeVerifying(t *testing.T) {
+	s, mailer := setupHardenedService()
+
+	if err := s.SignUp("test@user.com", "firstpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	first := mailer.tokens["test@user.com"]
+	if err := s.SignUp("test@user.com", "secondpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	second := mailer.tokens["test@user.com"]
+
+	// The password is the one of the link used
+	if err := s.VerifyEmail(first); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.SignIn("test@user.com", "firstpassword", ""); err != nil {
+		t.Errorf("expected the first password, got %v", err)
+	}
+	if err := s.VerifyEmail(second); err != ErrInvalidVerification {
+		t.Errorf("expected the other link to be spent, got %v", err)
+	}
+}
+
+func TestHardened_SignInUnknownUser(t *testing.T) {
+	s, _ := setupHardenedService()
+
+	if err := s.SignIn("unknown@user.com", "password", ""); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if dummy := s.dummyUser(); len(dummy.Password) == 0 {
+		t.Errorf("expected a dummy hash to be checked")
+	}
+	if err := s.VerifyEmail(""); err != ErrInvalidVerification {
+		t.Errorf("expected ErrInvalidVerification, got %v", err)
+	}
+}
+
+func TestHardened_VerifyAfterAccountCreated(t *testing.T) {
+	s, mailer := setupHardenedService()
+
+	if err := s.SignUp("test@user.com", "firstpassword"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// Created meanwhile, e.g. by an import
+	if err := s.store.Create(&User{Email: "test@user.com", Password: []byte("imported")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.VerifyEmail(mailer.tokens["test@user.com"]); err != ErrInvalidVerification {
+		t.Errorf("expected ErrInvalidVerification, got %v", err)
+	}
+	if user, _ := s.store.GetByEmail("test@user.com"); string(user.Password) != "imported" {
+		t.Errorf("expected the existing account to be kept")
+	}
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	s, mailer := setupHardenedService()
+	s.config.VerificationLifetime = -time.Minute
+
+	if err := s.SignUp("test@user.com", "password"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.VerifyEmail(mailer.tokens["test@user.com"]); err != ErrInvalidVerification {
+		t.Errorf("expected an expired token to be rejected, got %v", err)
+	}
+}
@@ -10,7 +10,54 @@ import (
 
 const DEFAULT_SQLITE_PATH = "sessions.db"
 
-func Init() (*sql.DB, error) {
+// MEMORY_TYPE selects the in-memory stores, which do not use a SQL database
+const MEMORY_TYPE = "memory"
+
+type adapter interface {
+	Open() (*sql.DB, error)
+	Dialect() Dialect
+}
+
+// Init connects to the configured database and applies any pending migration.
+func Init() (*sql.DB, Dialect, error) {
+	db, dialect, err := Connect()
+	if err != nil {
+		return nil, "", err
+	}
+
+	applied, err := Migrate(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, "", fmt.Errorf("failed to migrate database: %w", err)
+	}
+	for _, migration := range applied {
+		slog.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+	}
+
+	return db, dialect, nil
+}
+
+// InMemory reports whether the environment selects the in-memory backend.
+func InMemory() bool {
+	return strings.ToLower(os.Getenv("DATABASE_TYPE")) == MEMORY_TYPE
+}
+
+// Connect opens the database configured through the environment, without migrating it.
+func Connect() (*sql.DB, Dialect, error) {
+	database, err := fromEnv()
+	if err != nil {
+		return nil, "", err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return db, database.Dialect(), nil
+}
+
+func fromEnv() (adapter, error) {
 	db_url := os.Getenv("DATABASE_URL")
 	db_type := os.Getenv("DATABASE_TYPE")
 
@@ -19,16 +66,21 @@ func Init() (*sql.DB, error) {
 		slog.Info("No database type specified, defaulting to sqlite")
 	}
 
-	if len(db_url) == 0 {
-		slog.Info("No database url specified, defaulting to sqlite file 'sessions.db'")
-		db_url = DEFAULT_SQLITE_PATH
-	}
-
-	if strings.ToLower(db_type) == "sqlite" {
-		database := SQLite{path: db_url}
-		return database.Init(), nil
+	switch strings.ToLower(db_type) {
+	case "sqlite":
+		if len(db_url) == 0 {
+			slog.Info("No database url specified, defaulting to sqlite file 'sessions.db'")
+			db_url = DEFAULT_SQLITE_PATH
+		}
+		return &SQLite{path: db_url}, nil
+	case "postgres", "postgresql":
+		if len(db_url) == 0 {
+			return nil, fmt.Errorf("DATABASE_URL is required for database type: %s", db_type)
+		}
+		return &Postgres{url: db_url}, nil
+	case MEMORY_TYPE:
+		return nil, fmt.Errorf("database type %s has no SQL connection", db_type)
 	}
 
-	err := "Unknown database type: " + db_type
-	return nil, fmt.Errorf(err)
+	return nil, fmt.Errorf("Unknown database type: %s", db_type)
 }
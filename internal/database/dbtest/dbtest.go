@@ -0,0 +1,24 @@
+// Package dbtest opens the databases of the store tests
+package dbtest
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/aloysb/auth-session/internal/database"
+	_ "github.com/mattn/go-sqlite3" // Import SQLite driver
+)
+
+// SQLite returns a migrated SQLite database in a temporary directory, closed with the test
+func SQLite(t testing.TB) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(db, database.SQLiteDialect); err != nil {
+		t.Fatalf("failed to migrate test database: %s", err)
+	}
+	return db
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration files live in migrations/<dialect>/NNNN_description.sql and are
+// applied in version order.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Arbitrary key used to serialise migrations across postgres replicas
+const migrationLockKey = 724_617_301
+
+// Dialect identifies the SQL flavour of a database, and the migration set used for it
+type Dialect string
+
+const (
+	SQLiteDialect   Dialect = "sqlite"
+	PostgresDialect Dialect = "postgres"
+)
+
+// Migration is a single, versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns every embedded migration for the dialect, ordered by version.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %w", dialect, err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		prefix, name, found := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// PendingMigrations lists the migrations that have not been applied yet, without applying them.
+func PendingMigrations(db *sql.DB, dialect Dialect) ([]Migration, error) {
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+
+	return pending(migrations, applied), nil
+}
+
+// Migrate applies every pending migration, each in its own transaction, and
+// returns the ones it applied. Running it against an up-to-date database is a no-op.
+func Migrate(db *sql.DB, dialect Dialect) ([]Migration, error) {
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	// Use a single connection so the postgres advisory lock covers every step
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if dialect == PostgresDialect {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return nil, fmt.Errorf("could not acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+          version INTEGER PRIMARY KEY,
+          name TEXT NOT NULL,
+          applied_at TIMESTAMP NOT NULL
+       );
+   `)
+	if err != nil {
+		return nil, fmt.Errorf("could not create schema_migrations table: %w", err)
+	}
+
+	// Read the applied versions once the lock is held, another replica may have just migrated
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	todo := pending(migrations, applied)
+	for _, migration := range todo {
+		if err := apply(ctx, conn, migration); err != nil {
+			return nil, err
+		}
+	}
+
+	return todo, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func appliedVersions(ctx context.Context, db queryer) (map[int]bool, error) {
+	applied := make(map[int]bool)
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		// A database that was never migrated has no version table yet
+		if !tableExists(ctx, db, "schema_migrations") {
+			return applied, nil
+		}
+		return nil, fmt.Errorf("could not query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("could not read schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+func tableExists(ctx context.Context, db queryer, table string) bool {
+	rows, err := db.QueryContext(ctx, "SELECT 1 FROM "+table+" WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+func pending(migrations []Migration, applied map[int]bool) []Migration {
+	var todo []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			todo = append(todo, migration)
+		}
+	}
+	return todo
+}
+
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("could not record migration %d: %w", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // Import SQLite driver
+)
+
+func setupDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test_migrations.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrations_Ordered(t *testing.T) {
+	for _, dialect := range []Dialect{SQLiteDialect, PostgresDialect} {
+		migrations, err := Migrations(dialect)
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", dialect, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("expected migrations for %s", dialect)
+		}
+		for i, migration := range migrations {
+			if migration.Version != i+1 {
+				t.Errorf("expected %s migration %d to have version %d, got %d", dialect, i, i+1, migration.Version)
+			}
+		}
+	}
+}
+
+func TestMigrate_Idempotent(t *testing.T) {
+	db := setupDB(t)
+
+	pending, err := PendingMigrations(db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	all, _ := Migrations(SQLiteDialect)
+	if len(pending) != len(all) {
+		t.Fatalf("expected %d pending migrations on a fresh database, got %d", len(all), len(pending))
+	}
+
+	applied, err := Migrate(db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(applied) != len(all) {
+		t.Errorf("expected %d applied migrations, got %d", len(all), len(applied))
+	}
+
+	applied, err = Migrate(db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("expected no error on second run, got %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no migration on second run, got %d", len(applied))
+	}
+
+	pending, err = PendingMigrations(db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending migration, got %d", len(pending))
+	}
+}
+
+func TestMigrate_KeepsSessions(t *testing.T) {
+	db := setupDB(t)
+
+	if _, err := Migrate(db, SQLiteDialect); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, err := db.Exec("INSERT INTO sessions (id, user_id, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP)", "session", "user")
+	if err != nil {
+		t.Fatalf("failed to insert session: %v", err)
+	}
+
+	// Booting again must not log everybody out
+	if _, err := Migrate(db, SQLiteDialect); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count); err != nil {
+		t.Fatalf("failed to query database: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected session to survive migrations, found %d records", count)
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE sessions
+  ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
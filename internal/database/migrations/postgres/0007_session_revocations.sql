@@ -0,0 +1,12 @@
+-- Revocations of signed session tokens, kept until the tokens expire
+CREATE TABLE IF NOT EXISTS session_revocations (
+  session_id TEXT NOT NULL DEFAULT '',
+  user_id TEXT NOT NULL DEFAULT '',
+  except_id TEXT NOT NULL DEFAULT '',
+  revoked_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS session_revocations_session_id_idx ON session_revocations (session_id);
+CREATE INDEX IF NOT EXISTS session_revocations_user_id_idx ON session_revocations (user_id);
+CREATE INDEX IF NOT EXISTS session_revocations_expires_at_idx ON session_revocations (expires_at);
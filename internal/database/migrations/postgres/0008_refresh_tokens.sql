@@ -0,0 +1,24 @@
+-- Refresh tokens, chained in a family per login so that a reused token revokes them all
+CREATE TABLE IF NOT EXISTS token_families (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  session_id TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS token_families_session_id_idx ON token_families (session_id);
+CREATE INDEX IF NOT EXISTS token_families_user_id_idx ON token_families (user_id);
+CREATE INDEX IF NOT EXISTS token_families_expires_at_idx ON token_families (expires_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id TEXT PRIMARY KEY,
+  family_id TEXT NOT NULL REFERENCES token_families (id),
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
@@ -0,0 +1,16 @@
+-- Sessions used to be recreated on every boot with a SERIAL id, recreate the
+-- table once keyed by the hashed session token.
+DROP TABLE IF EXISTS sessions;
+
+CREATE TABLE sessions (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  email TEXT NOT NULL UNIQUE,
+  password BLOB NOT NULL,
+  salt BLOB NOT NULL
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
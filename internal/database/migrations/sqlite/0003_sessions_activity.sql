@@ -0,0 +1,5 @@
+-- SQLite cannot add a column with a non-constant default, backfill existing rows instead
+ALTER TABLE sessions ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+
+UPDATE sessions SET created_at = CURRENT_TIMESTAMP, last_seen_at = CURRENT_TIMESTAMP;
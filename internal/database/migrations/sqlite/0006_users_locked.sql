@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN locked BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- Hardened sign ups only insert the user once their email is verified, until then
+-- the password hash of each sign up waits in its verification.
+CREATE TABLE IF NOT EXISTS email_verifications (
+  id TEXT PRIMARY KEY,
+  email TEXT NOT NULL,
+  password BLOB NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_expires_at_idx ON email_verifications (expires_at);
+
+-- Using a verification spends the other ones of its email
+CREATE INDEX IF NOT EXISTS email_verifications_email_idx ON email_verifications (email);
@@ -0,0 +1,10 @@
+-- Failed sign ins per account and per client IP, so that lockouts hold across replicas
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  window_start TIMESTAMP NOT NULL,
+  lockouts INTEGER NOT NULL,
+  locked_until TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_window_start_idx ON login_attempts (window_start);
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+type Postgres struct {
+	url string
+}
+
+// Postgres adapter
+func (d *Postgres) Open() (*sql.DB, error) {
+	db, err := sql.Open("postgres", d.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres connection: %w", err)
+	}
+
+	// sql.Open does not connect, make sure the database is reachable
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	return db, nil
+}
+
+func (d *Postgres) Dialect() Dialect {
+	return PostgresDialect
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// Browsers only accept __Host- cookies that are Secure, scoped to "/" and without a Domain
+const hostPrefix = "__Host-"
+
+// Appended to the session cookie name to name the refresh token cookie
+const refreshSuffix = "_refresh"
+
+// CookieConfig controls the attributes of the session cookie
+type CookieConfig struct {
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+	// Persistent cookies carry a Max-Age matching the session expiry, set again
+	// each time the session is validated from the cookie as the expiry slides.
+	// Otherwise the cookie is dropped when the browser closes.
+	Persistent bool
+	// HostPrefix prefixes the cookie name with __Host-, locking it to the exact host
+	HostPrefix bool
+}
+
+// DefaultCookieConfig returns a Secure, host-wide, SameSite=Lax session cookie
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     session.COOKIE_NAME,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Validate checks for attribute combinations that browsers reject
+func (c CookieConfig) Validate() error {
+	if c.HostPrefix {
+		if !c.Secure {
+			return errors.New("__Host- cookies must be Secure")
+		}
+		if c.Domain != "" {
+			return errors.New("__Host- cookies cannot have a Domain")
+		}
+		if c.Path != "/" {
+			return errors.New(`__Host- cookies must have the "/" Path`)
+		}
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return errors.New("SameSite=None cookies must be Secure")
+	}
+	return nil
+}
+
+// ParseSameSite reads a SameSite mode from its attribute value
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("invalid SameSite mode: %s", value)
+}
+
+// name returns the cookie name, including its prefix
+func (c CookieConfig) name() string {
+	if c.HostPrefix {
+		return hostPrefix + c.Name
+	}
+	return c.Name
+}
+
+// sessionCookie builds the cookie carrying the token of a session
+func (c CookieConfig) sessionCookie(token session.Token, expiresAt time.Time) *http.Cookie {
+	cookie := c.cookie(string(token))
+	if c.Persistent {
+		cookie.Expires = expiresAt
+		cookie.MaxAge = int(time.Until(expiresAt).Seconds())
+	}
+	return cookie
+}
+
+// clearCookie builds a cookie telling the browser to drop the session cookie
+func (c CookieConfig) clearCookie() *http.Cookie {
+	cookie := c.cookie("")
+	cookie.MaxAge = -1
+	return cookie
+}
+
+// refreshName returns the name of the refresh token cookie, including its prefix
+func (c CookieConfig) refreshName() string {
+	return c.name() + refreshSuffix
+}
+
+// refreshCookie builds the cookie carrying a refresh token, it shares the attributes of the session cookie
+func (c CookieConfig) refreshCookie(token session.Token, expiresAt time.Time) *http.Cookie {
+	cookie := c.sessionCookie(token, expiresAt)
+	cookie.Name = c.refreshName()
+	return cookie
+}
+
+// clearRefreshCookie builds a cookie telling the browser to drop the refresh cookie
+func (c CookieConfig) clearRefreshCookie() *http.Cookie {
+	cookie := c.clearCookie()
+	cookie.Name = c.refreshName()
+	return cookie
+}
+
+func (c CookieConfig) cookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.name(),
+		Value:    value,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
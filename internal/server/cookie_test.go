@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+func loginRecorder(t *testing.T, config Config, expiresAt time.Time) *httptest.ResponseRecorder {
+	mockSessionService := &MockSessionService{
+		CreateSessionFunc: func(userID string, metadata session.Metadata) (*session.Session, session.Token, error) {
+			return &session.Session{UserId: userID, ExpiresAt: expiresAt}, "mockToken", nil
+		},
+	}
+	basicAuthService := &MockBasicAuthService{
+		SignInFunc: func(email string, password string, ip string) error {
+			return nil
+		},
+	}
+
+	srv := New(mockSessionService, basicAuthService, config)
+
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString("email=valid@email.com&password=validPassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.loginHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	return rr
+}
+
+func TestLoginCookie_Defaults(t *testing.T) {
+	rr := loginRecorder(t, DefaultConfig(), time.Now().Add(time.Hour))
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a single cookie, got %d", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != session.COOKIE_NAME || cookie.Value != "mockToken" {
+		t.Errorf("unexpected cookie: %+v", cookie)
+	}
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+		t.Errorf("expected a Secure, HttpOnly, SameSite=Lax cookie on /, got %+v", cookie)
+	}
+	if cookie.MaxAge != 0 || !cookie.Expires.IsZero() {
+		t.Errorf("expected a browser session cookie, got %+v", cookie)
+	}
+}
+
+func TestLoginCookie_Configured(t *testing.T) {
+	config := DefaultConfig()
+	config.Cookie = CookieConfig{
+		Name:       "sid",
+		Domain:     "example.com",
+		Path:       "/app",
+		Secure:     true,
+		SameSite:   http.SameSiteStrictMode,
+		Persistent: true,
+	}
+	rr := loginRecorder(t, config, time.Now().Add(time.Hour))
+
+	cookie := rr.Result().Cookies()[0]
+	if cookie.Name != "sid" || cookie.Domain != "example.com" || cookie.Path != "/app" || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("unexpected cookie attributes: %+v", cookie)
+	}
+	if cookie.MaxAge < 3590 || cookie.MaxAge > 3600 {
+		t.Errorf("expected Max-Age aligned to the session expiry, got %d", cookie.MaxAge)
+	}
+}
+
+func TestLoginCookie_HostPrefix(t *testing.T) {
+	config := DefaultConfig()
+	config.Cookie.HostPrefix = true
+	rr := loginRecorder(t, config, time.Now().Add(time.Hour))
+
+	cookie := rr.Result().Cookies()[0]
+	if cookie.Name != "__Host-"+session.COOKIE_NAME {
+		t.Errorf("expected a __Host- prefixed cookie, got %s", cookie.Name)
+	}
+
+	// The prefixed cookie is the one read back
+	srv := New(&MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			return &session.Session{UserId: "testUser"}, nil
+		},
+	}, &MockBasicAuthService{}, config)
+	req := httptest.NewRequest("POST", "/authenticate", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(srv.validateSessionHandler).ServeHTTP(rec, req)
+	if status := rec.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestPersistentCookie_Renewed(t *testing.T) {
+	config := DefaultConfig()
+	config.Cookie.Persistent = true
+	srv := New(&MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			// Validation slid the session
+			return &session.Session{UserId: "testUser", ExpiresAt: time.Now().Add(2 * time.Hour)}, nil
+		},
+	}, &MockBasicAuthService{}, config)
+
+	// From the cookie, it is set again with the new expiry
+	req := httptest.NewRequest("POST", "/authenticate", nil)
+	req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "mockToken"})
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(srv.validateSessionHandler).ServeHTTP(rec, req)
+	if status := rec.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "mockToken" {
+		t.Fatalf("expected the session cookie to be set again, got %+v", cookies)
+	}
+	if cookies[0].MaxAge < 7190 || cookies[0].MaxAge > 7200 {
+		t.Errorf("expected Max-Age aligned to the new expiry, got %d", cookies[0].MaxAge)
+	}
+
+	// From the Authorization header, no cookie is set
+	req = httptest.NewRequest("POST", "/authenticate", nil)
+	req.Header.Set("Authorization", "Bearer mockToken")
+	rec = httptest.NewRecorder()
+	http.HandlerFunc(srv.validateSessionHandler).ServeHTTP(rec, req)
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected no cookie for a bearer token, got %+v", cookies)
+	}
+}
+
+func TestCookieConfig_Validate(t *testing.T) {
+	valid := DefaultCookieConfig()
+	valid.HostPrefix = true
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected the default cookie to accept the __Host- prefix, got %v", err)
+	}
+
+	invalid := map[string]func(c *CookieConfig){
+		"host prefix without Secure": func(c *CookieConfig) { c.HostPrefix = true; c.Secure = false },
+		"host prefix with Domain":    func(c *CookieConfig) { c.HostPrefix = true; c.Domain = "example.com" },
+		"host prefix with Path":      func(c *CookieConfig) { c.HostPrefix = true; c.Path = "/app" },
+		"SameSite=None over HTTP":    func(c *CookieConfig) { c.SameSite = http.SameSiteNoneMode; c.Secure = false },
+	}
+	for name, mutate := range invalid {
+		config := DefaultCookieConfig()
+		mutate(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
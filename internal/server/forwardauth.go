@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/aloysb/auth-session/middleware"
+	"github.com/aloysb/auth-session/session"
+)
+
+// Headers set on the forward-auth response, for the proxy to copy to the upstream request
+const (
+	HeaderUserId    = "X-Auth-User-Id"
+	HeaderEmail     = "X-Auth-Email"
+	HeaderSessionId = "X-Auth-Session-Id"
+)
+
+// Query parameter carrying the original URL to the login page, unless configured otherwise
+const DefaultReturnParam = "return_to"
+
+// forwardAuthHandler implements the forward-auth protocol of nginx auth_request,
+// Traefik ForwardAuth and Caddy forward_auth: 2xx lets the request through with
+// the identity headers, anything else is sent back to the client.
+func (s *Server) forwardAuthHandler(w http.ResponseWriter, r *http.Request) {
+	token, ses, err := s.forwardAuthSession(r)
+	if err != nil {
+		if !errors.Is(err, session.ErrInvalidSession) && !errors.Is(err, session.ErrExpiredSession) &&
+			!errors.Is(err, middleware.ErrNoToken) && !errors.Is(err, middleware.ErrMalformedAuthorization) {
+			slog.Error("Error validating session", "error", err)
+			http.Error(w, "Error validating session", http.StatusInternalServerError)
+			return
+		}
+		s.denyForwardAuth(w, r)
+		return
+	}
+
+	// Users are identified by their email, both headers carry it
+	w.Header().Set(HeaderUserId, ses.UserId)
+	w.Header().Set(HeaderEmail, ses.UserId)
+	w.Header().Set(HeaderSessionId, string(ses.Id))
+	s.renewCookie(w, r, token, ses.ExpiresAt)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) forwardAuthSession(r *http.Request) (session.Token, *session.Session, error) {
+	token, err := s.sessionToken(r)
+	if err != nil {
+		return "", nil, err
+	}
+	ses, err := s.sessionService.ValidateSession(token)
+	return token, ses, err
+}
+
+// denyForwardAuth redirects to the login page when one is configured, and answers 401 otherwise.
+// nginx auth_request only understands 401 and 403, leave the login URL empty behind nginx.
+func (s *Server) denyForwardAuth(w http.ResponseWriter, r *http.Request) {
+	if s.config.ForwardAuth.LoginURL == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loginURL, err := url.Parse(s.config.ForwardAuth.LoginURL)
+	if err != nil {
+		slog.Error("Invalid forward-auth login URL", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if original := originalURL(r); original != "" {
+		param := s.config.ForwardAuth.ReturnParam
+		if param == "" {
+			param = DefaultReturnParam
+		}
+		query := loginURL.Query()
+		query.Set(param, original)
+		loginURL.RawQuery = query.Encode()
+	}
+
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+// originalURL rebuilds the URL the client asked the proxy for, from the forwarded headers
+func originalURL(r *http.Request) string {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return uri
+	}
+
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "https"
+	}
+	return proto + "://" + host + uri
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+func newForwardAuthServer(forwardAuth ForwardAuthConfig) *Server {
+	mockSessionService := &MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			switch token {
+			case "validToken":
+				return &session.Session{UserId: "user@example.com", Id: "sessionId"}, nil
+			case "expiredToken":
+				return nil, session.ErrExpiredSession
+			default:
+				return nil, session.ErrInvalidSession
+			}
+		},
+	}
+	config := DefaultConfig()
+	config.ForwardAuth = forwardAuth
+	return New(mockSessionService, &MockBasicAuthService{}, config)
+}
+
+func TestForwardAuthHandler_Success(t *testing.T) {
+	srv := newForwardAuthServer(ForwardAuthConfig{})
+
+	for _, method := range []string{"GET", "HEAD", "POST"} {
+		req := httptest.NewRequest(method, "/forward-auth", nil)
+		req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "validToken"})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.forwardAuthHandler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", method, rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get(HeaderUserId); got != "user@example.com" {
+			t.Errorf("%s: unexpected %s: %q", method, HeaderUserId, got)
+		}
+		if got := rr.Header().Get(HeaderEmail); got != "user@example.com" {
+			t.Errorf("%s: unexpected %s: %q", method, HeaderEmail, got)
+		}
+		if got := rr.Header().Get(HeaderSessionId); got != "sessionId" {
+			t.Errorf("%s: unexpected %s: %q", method, HeaderSessionId, got)
+		}
+	}
+}
+
+func TestForwardAuthHandler_Unauthorized(t *testing.T) {
+	srv := newForwardAuthServer(ForwardAuthConfig{})
+
+	tests := []struct {
+		name          string
+		cookie        string
+		authorization string
+	}{
+		{name: "no token"},
+		{name: "invalid session", cookie: "invalidToken"},
+		{name: "expired session", cookie: "expiredToken"},
+		{name: "malformed authorization", authorization: "Basic dXNlcjpwYXNz"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/forward-auth", nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: tt.cookie})
+		}
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.forwardAuthHandler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusUnauthorized)
+		}
+		if rr.Header().Get(HeaderUserId) != "" {
+			t.Errorf("%s: identity header set on a rejected request", tt.name)
+		}
+	}
+}
+
+func TestForwardAuthHandler_Redirect(t *testing.T) {
+	srv := newForwardAuthServer(ForwardAuthConfig{LoginURL: "https://auth.example.com/login?app=web"})
+
+	req := httptest.NewRequest("GET", "/forward-auth", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "app.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/dashboard?tab=1")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.forwardAuthHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid Location header: %v", err)
+	}
+	if location.Host != "auth.example.com" || location.Path != "/login" {
+		t.Errorf("unexpected redirect target: %v", location)
+	}
+	if got := location.Query().Get("app"); got != "web" {
+		t.Errorf("login URL query was not preserved: app=%q", got)
+	}
+	if got := location.Query().Get(DefaultReturnParam); got != "https://app.example.com/dashboard?tab=1" {
+		t.Errorf("unexpected return URL: %q", got)
+	}
+}
+
+func TestOriginalURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "no headers", want: ""},
+		{name: "uri only", headers: map[string]string{"X-Forwarded-Uri": "/a"}, want: "/a"},
+		{name: "nginx original uri", headers: map[string]string{"X-Original-URI": "/b"}, want: "/b"},
+		{name: "host defaults to https", headers: map[string]string{"X-Forwarded-Host": "app.example.com", "X-Forwarded-Uri": "/c"}, want: "https://app.example.com/c"},
+		{name: "full", headers: map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "localhost:3000", "X-Forwarded-Uri": "/d"}, want: "http://localhost:3000/d"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/forward-auth", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		if got := originalURL(req); got != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
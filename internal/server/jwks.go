@@ -0,0 +1,15 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// jwksHandler publishes the public keys verifying signed session tokens. Clients may
+// cache them for one reload interval: a new key must be published for at least this
+// long before it is activated, so that verifiers know it when it signs tokens.
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	maxAge := int(s.config.KeysReloadInterval.Seconds())
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	writeJSON(w, s.config.Keys.JWKS())
+}
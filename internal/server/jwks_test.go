@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/keys"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	seed, _ := keys.GenerateSeed()
+	manager, err := keys.FromSeeds(seed)
+	if err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+	config := DefaultConfig()
+	config.Keys = manager
+	config.KeysReloadInterval = 5 * time.Minute
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, config)
+
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var set keys.JWKS
+	if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+		t.Fatalf("failed to decode JWKS: %v", err)
+	}
+	kid, _ := manager.SigningKey()
+	if len(set.Keys) != 1 || set.Keys[0].KeyId != kid {
+		t.Errorf("unexpected key set: %+v", set)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("expected the key set to be cacheable for the reload interval, got %q", got)
+	}
+}
+
+func TestJWKSHandler_OpaqueTokens(t *testing.T) {
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
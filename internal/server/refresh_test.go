@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+func newRefreshServer(idleTimeout time.Duration) http.Handler {
+	config := session.DefaultConfig()
+	config.IdleTimeout = idleTimeout
+	config.RefreshTokenLifetime = 24 * time.Hour
+	sessions := session.New(session.NewMemoryStore(), config)
+
+	mockAuthService := &MockBasicAuthService{
+		SignInFunc: func(email string, password string, ip string) error { return nil },
+	}
+	serverConfig := DefaultConfig()
+	serverConfig.RefreshTokens = true
+	return New(sessions, mockAuthService, serverConfig).Handler()
+}
+
+func postForm(handler http.Handler, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRefreshHandler_Bearer(t *testing.T) {
+	handler := newRefreshServer(15 * time.Minute)
+
+	rr := postForm(handler, "/login", url.Values{"email": {"user@example.com"}, "password": {"password"}, "transport": {"bearer"}})
+	var login SessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if login.Token == "" || login.RefreshToken == "" {
+		t.Fatalf("expected a token and a refresh token, got %+v", login)
+	}
+
+	rr = postForm(handler, "/refresh", url.Values{"refresh_token": {string(login.RefreshToken)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var refreshed SessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if refreshed.Token == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Errorf("expected new tokens, got %+v", refreshed)
+	}
+	if refreshed.Session.UserId != "user@example.com" {
+		t.Errorf("unexpected session %+v", refreshed.Session)
+	}
+
+	// Replaying the first refresh token logs the family out
+	rr = postForm(handler, "/refresh", url.Values{"refresh_token": {string(login.RefreshToken)}})
+	if rr.Code != http.StatusUnauthorized || strings.TrimSpace(rr.Body.String()) != session.ErrRefreshTokenReused.Error() {
+		t.Errorf("expected a 401 for a reused token, got %v %q", rr.Code, rr.Body.String())
+	}
+	rr = postForm(handler, "/refresh", url.Values{"refresh_token": {string(refreshed.RefreshToken)}})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 for a revoked family, got %v", rr.Code)
+	}
+}
+
+func TestRefreshHandler_Cookie(t *testing.T) {
+	handler := newRefreshServer(15 * time.Minute)
+
+	rr := postForm(handler, "/login", url.Values{"email": {"user@example.com"}, "password": {"password"}})
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rr.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	refreshCookie := cookies[session.COOKIE_NAME+refreshSuffix]
+	if refreshCookie == nil || !refreshCookie.HttpOnly || cookies[session.COOKIE_NAME] == nil {
+		t.Fatalf("expected the session and refresh cookies, got %v", rr.Result().Cookies())
+	}
+	if strings.Contains(rr.Body.String(), "refresh_token") {
+		t.Errorf("expected the refresh token to stay out of the body, got %s", rr.Body.String())
+	}
+
+	rr = postForm(handler, "/refresh", url.Values{}, refreshCookie)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if len(rr.Result().Cookies()) != 2 {
+		t.Errorf("expected new session and refresh cookies, got %v", rr.Result().Cookies())
+	}
+
+	// A reused cookie is cleared
+	rr = postForm(handler, "/refresh", url.Values{}, refreshCookie)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 for a reused token, got %v", rr.Code)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("expected cookie %s to be cleared", cookie.Name)
+		}
+	}
+
+	if rr := postForm(handler, "/refresh", url.Values{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a 400 without a refresh token, got %v", rr.Code)
+	}
+}
+
+func TestLogoutHandler_RevokesRefreshTokens(t *testing.T) {
+	// Access sessions expire at once
+	handler := newRefreshServer(time.Nanosecond)
+
+	// Cookie clients send the refresh cookie along with the expired session cookie
+	rr := postForm(handler, "/login", url.Values{"email": {"user@example.com"}, "password": {"password"}})
+	cookies := rr.Result().Cookies()
+	if rr := postForm(handler, "/logout", url.Values{}, cookies...); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == session.COOKIE_NAME+refreshSuffix {
+			if rr := postForm(handler, "/refresh", url.Values{}, cookie); rr.Code != http.StatusUnauthorized {
+				t.Errorf("expected the refresh cookie to be revoked, got %v", rr.Code)
+			}
+		}
+	}
+
+	// Bearer clients send the refresh token, with or without the expired access token
+	rr = postForm(handler, "/login", url.Values{"email": {"user@example.com"}, "password": {"password"}, "transport": {"bearer"}})
+	var login SessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if rr := postForm(handler, "/logout", url.Values{"refresh_token": {string(login.RefreshToken)}}); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := postForm(handler, "/refresh", url.Values{"refresh_token": {string(login.RefreshToken)}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the refresh token to be revoked, got %v", rr.Code)
+	}
+}
+
+func TestRefreshHandler_Disabled(t *testing.T) {
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+
+	rr := postForm(srv.Handler(), "/refresh", url.Values{"refresh_token": {"token"}})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected /refresh not to be served, got %v", rr.Code)
+	}
+}
@@ -1,46 +1,149 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aloysb/auth-session/internal/auth"
-	"github.com/aloysb/auth-session/internal/session"
+	"github.com/aloysb/auth-session/keys"
+	"github.com/aloysb/auth-session/session"
 )
 
 // Response struct to encapsulate session and token
 type SessionResponse struct {
-	Session session.Session `json:"session"` // The session data
+	Session session.Session `json:"session"`         // The session data
+	Token   session.Token   `json:"token,omitempty"` // The session token, only for bearer clients
+	// The refresh token replacing the session once it expires, only for bearer clients
+	RefreshToken session.Token `json:"refresh_token,omitempty"`
+}
+
+// Config holds the HTTP server settings
+type Config struct {
+	// TrustProxyHeaders reads the client IP from X-Forwarded-For and X-Real-IP,
+	// only enable it behind a reverse proxy that sets them
+	TrustProxyHeaders bool
+	// TrustedProxies is the number of reverse proxies in front of the server, each
+	// appending to X-Forwarded-For. The client IP is the entry the outermost one
+	// appended, the ones on its left are sent by the client. Defaults to 1.
+	TrustedProxies int
+	// Cookie controls the attributes of the session cookie
+	Cookie CookieConfig
+	// ForwardAuth controls how /forward-auth turns away unauthenticated requests
+	ForwardAuth ForwardAuthConfig
+	// Keys are published at /.well-known/jwks.json when set, for signed session tokens
+	Keys *keys.Manager
+	// KeysReloadInterval is how often the keys are reloaded, verifiers may cache the key
+	// set for as long. Defaults to keys.DefaultReloadInterval.
+	KeysReloadInterval time.Duration
+	// RefreshTokens issues a refresh token along with every session, exchanged at /refresh.
+	// The session service must have a refresh token lifetime.
+	RefreshTokens bool
+	// Hardened answers sign ups with a 202 instead of a session, the user signs in once
+	// their email is verified at /verify. The auth service must be hardened as well.
+	Hardened bool
+}
+
+// ForwardAuthConfig controls the response of /forward-auth to unauthenticated requests
+type ForwardAuthConfig struct {
+	// LoginURL receives a 302 redirect when set, otherwise the response is a 401
+	LoginURL string
+	// ReturnParam is the query parameter carrying the original URL, defaults to return_to
+	ReturnParam string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Cookie: DefaultCookieConfig(),
+	}
 }
 
 type Server struct {
 	sessionService session.ISessionService
 	authService    auth.IBasicAuthService
+	config         Config
+	httpServer     *http.Server
 }
 
-func New(sessionService session.ISessionService, authService auth.IBasicAuthService) *Server {
-	return &Server{
+func New(sessionService session.ISessionService, authService auth.IBasicAuthService, config Config) *Server {
+	// Fill in the cookie attributes that cannot be left empty
+	if config.Cookie.Name == "" {
+		config.Cookie.Name = session.COOKIE_NAME
+	}
+	if config.Cookie.Path == "" {
+		config.Cookie.Path = "/"
+	}
+	if config.Cookie.SameSite == 0 {
+		config.Cookie.SameSite = http.SameSiteLaxMode
+	}
+	if config.TrustedProxies < 1 {
+		config.TrustedProxies = 1
+	}
+	if config.KeysReloadInterval <= 0 {
+		config.KeysReloadInterval = keys.DefaultReloadInterval
+	}
+	s := &Server{
 		sessionService: sessionService,
 		authService:    authService,
+		config:         config,
 	}
+	// Built upfront so that Shutdown can run concurrently with, and even before, Start
+	s.httpServer = &http.Server{Handler: s.Handler()}
+	return s
 }
 
-// StartServer initializes the HTTP server and routes
+// Start listens on the port and serves the routes, it blocks until the server is shut down.
+// It returns at once when Shutdown was called before.
 func (s *Server) Start(port int) {
-	http.HandleFunc("POST /login", s.loginHandler)
-	http.HandleFunc("POST /logout", s.logoutHandler)
-	http.HandleFunc("POST /authenticate", s.validateSessionHandler)
-	http.HandleFunc("POST /signup", s.signupHandler)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		fmt.Println("Error starting server:", err)
+		return
+	}
 
 	fmt.Printf("Server is running on port: %d\n", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Println("Error starting server:", err)
 	}
 }
 
+// Handler returns the routes of the server, to mount them on another server or in tests
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", s.loginHandler)
+	mux.HandleFunc("POST /logout", s.logoutHandler)
+	mux.HandleFunc("POST /authenticate", s.validateSessionHandler)
+	// Some proxies forward the method of the original request, accept them all
+	mux.HandleFunc("/forward-auth", s.forwardAuthHandler)
+	mux.HandleFunc("POST /signup", s.signupHandler)
+	mux.HandleFunc("POST /password", s.changePasswordHandler)
+	mux.HandleFunc("GET /sessions", s.listSessionsHandler)
+	mux.HandleFunc("DELETE /sessions", s.revokeOtherSessionsHandler)
+	mux.HandleFunc("DELETE /sessions/{id}", s.revokeSessionHandler)
+	if s.config.RefreshTokens {
+		mux.HandleFunc("POST /refresh", s.refreshHandler)
+	}
+	if s.config.Hardened {
+		mux.HandleFunc("POST /verify", s.verifyHandler)
+	}
+	if s.config.Keys != nil {
+		mux.HandleFunc("GET /.well-known/jwks.json", s.jwksHandler)
+	}
+	return mux
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight requests until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
 // loginHandler handles user login and creates a session
 func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
@@ -55,8 +158,11 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.authService.SignIn(email, password)
+	err := s.authService.SignIn(email, password, s.clientIP(r))
 	if err != nil {
+		if writeLockoutError(w, err) {
+			return
+		}
 		switch err {
 		case auth.ErrInvalidCredentials:
 			http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -64,33 +170,45 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		case auth.ErrUserNotFound:
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
+		case auth.ErrAccountLocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
-	token := s.sessionService.GenerateToken()
-	sess, err := s.sessionService.CreateSession(token, email)
-
-	cookie := http.Cookie{
-		Name:     session.COOKIE_NAME,
-		Value:    token,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		// TODO: change to secure
-		Secure: false,
-		MaxAge: 0,
-		Path:   "/",
-	}
-
+	sess, token, err := s.sessionService.CreateSession(email, s.metadata(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	refreshed := &session.Refreshed{Session: sess, Token: token}
+	if s.config.RefreshTokens {
+		refreshed.RefreshToken, refreshed.RefreshExpiresAt, err = s.sessionService.IssueRefreshToken(sess)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Clients that cannot use cookies get the token in the body instead
+	s.writeSession(w, refreshed, r.FormValue("transport") == bearerTransport)
+}
+
+// writeSession answers with the session, its tokens go in the body for bearer
+// clients and in cookies otherwise
+func (s *Server) writeSession(w http.ResponseWriter, refreshed *session.Refreshed, bearer bool) {
+	response := SessionResponse{Session: *refreshed.Session}
+	if bearer {
+		response.Token = refreshed.Token
+		response.RefreshToken = refreshed.RefreshToken
+	}
+
 	// Serialize the session struct to JSON
-	responseJSON, err := json.Marshal(&SessionResponse{Session: *sess})
+	responseJSON, err := json.Marshal(&response)
 	if err != nil {
 		http.Error(w, "Unable to serialize session", http.StatusInternalServerError)
 		return
@@ -98,10 +216,62 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Write the JSON response
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Set-Cookie", cookie.String())
+	if !bearer {
+		http.SetCookie(w, s.config.Cookie.sessionCookie(refreshed.Token, refreshed.Session.ExpiresAt))
+		if refreshed.RefreshToken != "" {
+			http.SetCookie(w, s.config.Cookie.refreshCookie(refreshed.RefreshToken, refreshed.RefreshExpiresAt))
+		}
+	}
 	w.Write(responseJSON)
 }
 
+// refreshHandler exchanges a refresh token for a new session and refresh token. The refresh
+// token is read from the refresh_token field for bearer clients, from its cookie otherwise.
+func (s *Server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken, bearer := s.refreshToken(r)
+	if refreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	refreshed, err := s.sessionService.Refresh(refreshToken, s.metadata(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrRefreshTokenReused):
+			slog.Warn("Refresh token reused, its sessions were revoked", "error", err)
+			s.clearCookies(w, bearer)
+			http.Error(w, session.ErrRefreshTokenReused.Error(), http.StatusUnauthorized)
+		case errors.Is(err, session.ErrInvalidRefreshToken):
+			s.clearCookies(w, bearer)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			http.Error(w, "Unable to refresh session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.writeSession(w, refreshed, bearer)
+}
+
+// clearCookies drops the session and refresh cookies of cookie clients
+func (s *Server) clearCookies(w http.ResponseWriter, bearer bool) {
+	if bearer {
+		return
+	}
+	http.SetCookie(w, s.config.Cookie.clearCookie())
+	if s.config.RefreshTokens {
+		http.SetCookie(w, s.config.Cookie.clearRefreshCookie())
+	}
+}
+
+// metadata describes the client of the request, recorded on the sessions it creates
+func (s *Server) metadata(r *http.Request) session.Metadata {
+	return session.Metadata{
+		IP:        s.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
 func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	if email == "" {
@@ -118,7 +288,9 @@ func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.authService.SignUp(email, password)
 
 	if err != nil {
-		fmt.Println(err)
+		if writePolicyError(w, err) {
+			return
+		}
 		switch err {
 		case auth.ErrInvalidEmail:
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -130,38 +302,114 @@ func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		default:
+			slog.Error("Error signing up", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
+	// The outcome was sent to the email, whether or not it had an account
+	if s.config.Hardened {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	s.loginHandler(w, r)
 }
 
-// validateSessionHandler checks if the session is valid
-func (s *Server) validateSessionHandler(w http.ResponseWriter, r *http.Request) {
-	// Log the request and headers
-	slog.Info("Received request: %s %s", r.Method, r.URL.Path)
-	slog.Info("Request Headers: %v", r.Header)
+// verifyHandler confirms the email a hardened sign up sent the token to
+func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
 
-	// Attempt to get the session cookie
-	cookie, err := r.Cookie(session.COOKIE_NAME)
-	if err != nil {
-		switch {
-		case errors.Is(err, http.ErrNoCookie):
-			slog.Info("No session cookie found")
-			http.Error(w, "cookie not found", http.StatusBadRequest)
+	if err := s.authService.VerifyEmail(token); err != nil {
+		switch err {
+		case auth.ErrInvalidVerification:
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		default:
-			slog.Info("Error retrieving cookie: %v", err)
-			http.Error(w, "server error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
-	slog.Info("Session Cookie: %v", cookie.Value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PolicyErrorResponse lists the rules of the password policy a new password breaks
+type PolicyErrorResponse struct {
+	Error      string   `json:"error"`
+	Violations []string `json:"violations"`
+}
+
+// writePolicyError answers with a 400 listing the violations when the password was
+// rejected by the policy, and reports whether it did
+func writePolicyError(w http.ResponseWriter, err error) bool {
+	var policyErr *auth.PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
 
-	// Validate the session using the cookie value
-	ses, err := s.sessionService.ValidateSession(cookie.Value)
+	responseJSON, err := json.Marshal(&PolicyErrorResponse{Error: auth.ErrWeakPassword.Error(), Violations: policyErr.Violations})
+	if err != nil {
+		http.Error(w, "Unable to serialize error", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(responseJSON)
+	return true
+}
+
+// writeLockoutError answers with a Retry-After header when signing in was refused
+// after too many failures, and reports whether it did: a 423 when the account is
+// locked out, a 429 when the client IP is
+func writeLockoutError(w http.ResponseWriter, err error) bool {
+	var lockoutErr *auth.LockoutError
+	if !errors.As(err, &lockoutErr) {
+		return false
+	}
+
+	status := http.StatusLocked
+	if errors.Is(err, auth.ErrTooManyAttempts) {
+		status = http.StatusTooManyRequests
+	}
+	// Rounded up, so that retrying on time is not refused again
+	retryAfter := (lockoutErr.RetryAfter + time.Second - 1) / time.Second
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+	http.Error(w, lockoutErr.Error(), status)
+	return true
+}
+
+// validateSessionHandler checks if the session is valid
+func (s *Server) validateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Received request", "method", r.Method, "path", r.URL.Path)
+
+	ses, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Log successful session validation
+	slog.Info("Session validated", "user_id", ses.UserId)
+	w.Write([]byte(ses.UserId))
+}
+
+// authenticate validates the session token of the request, from the Authorization
+// header or the cookie. On failure it writes the error response and returns false.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*session.Session, bool) {
+	// Attempt to get the session token
+	token, err := s.sessionToken(r)
+	if err != nil {
+		slog.Info("No session token found", "error", err)
+		writeTokenError(w, err)
+		return nil, false
+	}
+
+	// Validate the session using the token
+	ses, err := s.sessionService.ValidateSession(token)
 	if err != nil {
 		switch {
 		case errors.Is(err, session.ErrInvalidSession):
@@ -171,29 +419,116 @@ func (s *Server) validateSessionHandler(w http.ResponseWriter, r *http.Request)
 			slog.Info("Expired session")
 			http.Error(w, "Expired session", http.StatusUnauthorized)
 		default:
-			slog.Info("Error validating session: %v", err)
+			slog.Info("Error validating session", "error", err)
 			http.Error(w, "Error validating session", http.StatusInternalServerError)
 		}
+		return nil, false
+	}
+
+	s.renewCookie(w, r, token, ses.ExpiresAt)
+	return ses, true
+}
+
+// changePasswordHandler replaces the current user's password and logs them out everywhere else
+func (s *Server) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	// Log successful session validation
-	slog.Info("Session validated for User ID: %s", ses.UserId)
-	w.Write([]byte(ses.UserId))
+	currentPassword := r.FormValue("current_password")
+	if currentPassword == "" {
+		http.Error(w, "current_password is required", http.StatusBadRequest)
+		return
+	}
+
+	newPassword := r.FormValue("new_password")
+	if newPassword == "" {
+		http.Error(w, "new_password is required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.authService.ChangePassword(current.UserId, currentPassword, newPassword, current.Id)
+	if err != nil {
+		if writePolicyError(w, err) || writeLockoutError(w, err) {
+			return
+		}
+		switch err {
+		case auth.ErrInvalidCredentials:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case auth.ErrAccountLocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case auth.ErrEmptyPassword:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
+// logoutHandler invalidates the session of the request and clears its cookie.
+// A session that is already invalid or expired has nothing left to invalidate.
 func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie(session.COOKIE_NAME)
+	var refreshToken session.Token
+	if s.config.RefreshTokens {
+		refreshToken, _ = s.refreshToken(r)
+	}
+	token, tokenErr := s.sessionToken(r)
+	if tokenErr != nil && refreshToken == "" {
+		writeTokenError(w, tokenErr)
+		return
+	}
 
-	if err != nil {
+	if tokenErr == nil {
+		// The token is resolved to its session, signed tokens do not hash to their session id
+		ses, err := s.sessionService.ValidateSession(token)
 		switch {
-		case errors.Is(err, http.ErrNoCookie):
-			http.Error(w, "cookie not found", http.StatusBadRequest)
+		case err == nil:
+			if err := s.sessionService.InvalidateSession(ses.Id); err != nil {
+				http.Error(w, "Unable to invalidate session", http.StatusInternalServerError)
+				return
+			}
+		case errors.Is(err, session.ErrInvalidSession), errors.Is(err, session.ErrExpiredSession):
 		default:
-			http.Error(w, "server error", http.StatusInternalServerError)
+			http.Error(w, "Unable to invalidate session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The refresh token outlives the access session, its family is revoked by itself
+	if refreshToken != "" {
+		_, err := s.sessionService.RevokeRefreshToken(refreshToken)
+		if err != nil && !errors.Is(err, session.ErrInvalidRefreshToken) {
+			http.Error(w, "Unable to revoke refresh token", http.StatusInternalServerError)
+			return
 		}
-		return
 	}
 
-	s.sessionService.InvalidateSession(cookie.Value)
+	s.clearCookies(w, false)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP returns the address of the client, as reported by the proxy when trusted
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.TrustProxyHeaders {
+		// Proxies may append a header rather than an entry
+		if forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ","); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			// With fewer entries than proxies, every entry was appended by one of them
+			client := entries[max(len(entries)-s.config.TrustedProxies, 0)]
+			return strings.TrimSpace(client)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return realIP
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -2,64 +2,99 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
-	"github.com/aloysb/auth-session/internal/session"
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/session"
 )
 
 // MockSessionService is a mock implementation of session.ISessionService
 type MockSessionService struct {
-	CreateSessionFunc     func(token string, userID string) (*session.Session, error)
-	GenerateTokenFunc     func() string
-	ValidateSessionFunc   func(token string) (*session.Session, error)
-	InvalidateSessionFunc func(token string) error
+	CreateSessionFunc     func(userID string, metadata session.Metadata) (*session.Session, session.Token, error)
+	ValidateSessionFunc   func(token session.Token) (*session.Session, error)
+	InvalidateSessionFunc func(sessionId session.SessionID) error
+	ListSessionsFunc      func(userId string) ([]*session.Session, error)
+	RevokeSessionFunc     func(userId string, sessionId session.SessionID) error
+	RevokeOtherFunc       func(userId string, currentSessionId session.SessionID) (int, error)
+	IssueRefreshFunc      func(s *session.Session) (session.Token, time.Time, error)
+	RefreshFunc           func(refreshToken session.Token, metadata session.Metadata) (*session.Refreshed, error)
+	RevokeRefreshFunc     func(refreshToken session.Token) (session.SessionID, error)
 }
 
-func (m *MockSessionService) CreateSession(token string, userID string) (*session.Session, error) {
-	return m.CreateSessionFunc(token, userID)
+func (m *MockSessionService) CreateSession(userID string, metadata session.Metadata) (*session.Session, session.Token, error) {
+	return m.CreateSessionFunc(userID, metadata)
 }
 
-func (m *MockSessionService) GenerateToken() string {
-	return m.GenerateTokenFunc()
+func (m *MockSessionService) ValidateSession(token session.Token) (*session.Session, error) {
+	return m.ValidateSessionFunc(token)
 }
 
-func (m *MockSessionService) ValidateSession(token string) (*session.Session, error) {
-	return m.ValidateSessionFunc(token)
+func (m *MockSessionService) InvalidateSession(sessionId session.SessionID) error {
+	return m.InvalidateSessionFunc(sessionId)
+}
+
+func (m *MockSessionService) ListSessions(userId string) ([]*session.Session, error) {
+	return m.ListSessionsFunc(userId)
+}
+
+func (m *MockSessionService) RevokeSession(userId string, sessionId session.SessionID) error {
+	return m.RevokeSessionFunc(userId, sessionId)
+}
+
+func (m *MockSessionService) RevokeOtherSessions(userId string, currentSessionId session.SessionID) (int, error) {
+	return m.RevokeOtherFunc(userId, currentSessionId)
+}
+
+func (m *MockSessionService) IssueRefreshToken(s *session.Session) (session.Token, time.Time, error) {
+	return m.IssueRefreshFunc(s)
 }
 
-func (m *MockSessionService) InvalidateSession(token string) error {
-	return nil
+func (m *MockSessionService) Refresh(refreshToken session.Token, metadata session.Metadata) (*session.Refreshed, error) {
+	return m.RefreshFunc(refreshToken, metadata)
+}
+
+func (m *MockSessionService) RevokeRefreshToken(refreshToken session.Token) (session.SessionID, error) {
+	return m.RevokeRefreshFunc(refreshToken)
 }
 
 // MockBasicAuthService is a mock implementation of auth.BasicAuthService
 type MockBasicAuthService struct {
-	SignInFunc func(email string, password string) error
-	SignUpFunc func(email string, password string) error
+	SignInFunc         func(email string, password string, ip string) error
+	SignUpFunc         func(email string, password string) error
+	ChangePasswordFunc func(email, currentPassword, newPassword string, currentSessionId session.SessionID) error
+	VerifyEmailFunc    func(token string) error
 }
 
-func (m *MockBasicAuthService) SignIn(email string, password string) error {
-	return m.SignInFunc(email, password)
+func (m *MockBasicAuthService) SignIn(email string, password string, ip string) error {
+	return m.SignInFunc(email, password, ip)
 }
 
 func (m *MockBasicAuthService) SignUp(email string, password string) error {
 	return m.SignUpFunc(email, password)
 }
 
+func (m *MockBasicAuthService) ChangePassword(email, currentPassword, newPassword string, currentSessionId session.SessionID) error {
+	return m.ChangePasswordFunc(email, currentPassword, newPassword, currentSessionId)
+}
+
+func (m *MockBasicAuthService) VerifyEmail(token string) error {
+	return m.VerifyEmailFunc(token)
+}
+
 func TestLoginHandler_Success(t *testing.T) {
 	mockSessionService := &MockSessionService{
-		GenerateTokenFunc: func() string {
-			return "mockToken"
-		},
-		CreateSessionFunc: func(token string, userID string) (*session.Session, error) {
-			return &session.Session{UserId: userID}, nil
+		CreateSessionFunc: func(userID string, metadata session.Metadata) (*session.Session, session.Token, error) {
+			return &session.Session{UserId: userID, IP: metadata.IP, UserAgent: metadata.UserAgent}, "mockToken", nil
 		},
 	}
 
 	basicAuthService := &MockBasicAuthService{
-		SignInFunc: func(email string, password string) error {
+		SignInFunc: func(email string, password string, ip string) error {
 			return nil
 		},
 		SignUpFunc: func(email string, password string) error {
@@ -67,13 +102,15 @@ func TestLoginHandler_Success(t *testing.T) {
 		},
 	}
 
-	srv := New(mockSessionService, basicAuthService)
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
 
 	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString("email=valid@email.com&password=validPassword"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "test-agent")
+	req.RemoteAddr = "192.0.2.1:1234"
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(srv.loginHandler)
@@ -91,16 +128,22 @@ func TestLoginHandler_Success(t *testing.T) {
 	if response.Session.UserId != "valid@email.com" {
 		t.Errorf("unexpected response: got %+v", response)
 	}
+	if response.Session.IP != "192.0.2.1" || response.Session.UserAgent != "test-agent" {
+		t.Errorf("expected client metadata in response: got %+v", response.Session)
+	}
 	if len(rr.Result().Cookies()) == 0 {
 		t.Errorf("expected cookie to be set")
 	}
+	if response.Token != "" {
+		t.Errorf("expected no token in the body of cookie clients, got %q", response.Token)
+	}
 }
 
 func TestLoginHandler_MissingUserID(t *testing.T) {
 	mockSessionService := &MockSessionService{}
 	basicAuthService := &MockBasicAuthService{}
 
-	srv := New(mockSessionService, basicAuthService)
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
 
 	req, err := http.NewRequest("POST", "/login", nil)
 	if err != nil {
@@ -118,13 +161,13 @@ func TestLoginHandler_MissingUserID(t *testing.T) {
 
 func TestValidateSessionHandler_Success(t *testing.T) {
 	mockSessionService := &MockSessionService{
-		ValidateSessionFunc: func(token string) (*session.Session, error) {
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
 			return &session.Session{UserId: "testUser"}, nil
 		},
 	}
 	basicAuthService := &MockBasicAuthService{}
 
-	srv := New(mockSessionService, basicAuthService)
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
 
 	req, err := http.NewRequest("POST", "/validate", nil)
 	if err != nil {
@@ -149,7 +192,7 @@ func TestValidateSessionHandler_NoCookie(t *testing.T) {
 	mockSessionService := &MockSessionService{}
 	basicAuthService := &MockBasicAuthService{}
 
-	srv := New(mockSessionService, basicAuthService)
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
 
 	req, err := http.NewRequest("POST", "/validate", nil)
 	if err != nil {
@@ -166,14 +209,22 @@ func TestValidateSessionHandler_NoCookie(t *testing.T) {
 }
 
 func TestLogoutUserHandler_Success(t *testing.T) {
+	var invalidated session.SessionID
 	mockSessionService := &MockSessionService{
-		InvalidateSessionFunc: func(token string) error {
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			if token != "mockToken" {
+				return nil, session.ErrInvalidSession
+			}
+			return &session.Session{UserId: "testUser", Id: "mockSessionId"}, nil
+		},
+		InvalidateSessionFunc: func(sessionId session.SessionID) error {
+			invalidated = sessionId
 			return nil
 		},
 	}
 	basicAuthService := &MockBasicAuthService{}
 
-	srv := New(mockSessionService, basicAuthService)
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
 
 	req, err := http.NewRequest("POST", "/logout", nil)
 	if err != nil {
@@ -185,7 +236,239 @@ func TestLogoutUserHandler_Success(t *testing.T) {
 	handler := http.HandlerFunc(srv.logoutHandler)
 	handler.ServeHTTP(rec, req)
 
-	if status := rec.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	if status := rec.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if invalidated != "mockSessionId" {
+		t.Errorf("expected the session of the token to be invalidated, got %s", invalidated)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != session.COOKIE_NAME || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be cleared, got %+v", cookies)
+	}
+}
+
+func TestLogoutUserHandler_DeletesSession(t *testing.T) {
+	store := session.NewMemoryStore()
+	sessionService := session.New(store, session.DefaultConfig())
+	basicAuthService := &MockBasicAuthService{
+		SignInFunc: func(email string, password string, ip string) error {
+			return nil
+		},
+	}
+
+	srv := New(sessionService, basicAuthService, DefaultConfig())
+
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString("email=valid@email.com&password=validPassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(srv.loginHandler).ServeHTTP(rec, req)
+
+	var response SessionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if _, err := store.Get(response.Session.Id); err != nil {
+		t.Fatalf("expected session to be stored after login, got %v", err)
+	}
+
+	req, err = http.NewRequest("POST", "/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	http.HandlerFunc(srv.logoutHandler).ServeHTTP(rec, req)
+
+	if status := rec.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if _, err := store.Get(response.Session.Id); err != session.ErrSessionNotFound {
+		t.Errorf("expected session to be deleted on logout, got %v", err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+	if ip := srv.clientIP(req); ip != "192.0.2.1" {
+		t.Errorf("expected proxy headers to be ignored by default, got %s", ip)
+	}
+
+	// The leftmost entries are sent by the client, only the proxy's one is trusted
+	srv = New(&MockSessionService{}, &MockBasicAuthService{}, Config{TrustProxyHeaders: true})
+	if ip := srv.clientIP(req); ip != "10.0.0.1" {
+		t.Errorf("expected the entry appended by the proxy, got %s", ip)
+	}
+
+	srv = New(&MockSessionService{}, &MockBasicAuthService{}, Config{TrustProxyHeaders: true, TrustedProxies: 2})
+	if ip := srv.clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected the entry appended by the outermost proxy, got %s", ip)
+	}
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if ip := srv.clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected the only entry, got %s", ip)
+	}
+
+	// A proxy appending a header rather than an entry
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req.Header.Add("X-Forwarded-For", "203.0.113.7")
+	srv = New(&MockSessionService{}, &MockBasicAuthService{}, Config{TrustProxyHeaders: true})
+	if ip := srv.clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected the last header, got %s", ip)
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	mockSessionService := &MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			return &session.Session{UserId: "valid@email.com", Id: "current"}, nil
+		},
+	}
+	basicAuthService := &MockBasicAuthService{
+		ChangePasswordFunc: func(email, currentPassword, newPassword string, currentSessionId session.SessionID) error {
+			if email != "valid@email.com" || currentSessionId != "current" {
+				t.Errorf("unexpected change for %s keeping %s", email, currentSessionId)
+			}
+			if currentPassword != "validPassword" {
+				return auth.ErrInvalidCredentials
+			}
+			return nil
+		},
+	}
+
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
+
+	for body, want := range map[string]int{
+		"current_password=validPassword&new_password=newPassword": http.StatusNoContent,
+		"current_password=wrongPassword&new_password=newPassword": http.StatusForbidden,
+		"current_password=validPassword":                          http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest("POST", "/password", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "mockToken"})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.changePasswordHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", body, status, want)
+		}
+	}
+}
+
+func TestSignupHandler_WeakPassword(t *testing.T) {
+	basicAuthService := &MockBasicAuthService{
+		SignUpFunc: func(email string, password string) error {
+			return &auth.PolicyError{Violations: []string{auth.ViolationTooShort, auth.ViolationBreached}}
+		},
+	}
+	srv := New(&MockSessionService{}, basicAuthService, DefaultConfig())
+
+	req, err := http.NewRequest("POST", "/signup", bytes.NewBufferString("email=valid@email.com&password=short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.signupHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	var body PolicyErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body, got %s", rr.Body)
+	}
+	if body.Error != auth.ErrWeakPassword.Error() || len(body.Violations) != 2 {
+		t.Errorf("unexpected body %+v", body)
+	}
+}
+
+func TestLoginHandler_Lockout(t *testing.T) {
+	for name, tt := range map[string]struct {
+		err    error
+		status int
+	}{
+		"account": {&auth.LockoutError{Err: auth.ErrAccountTemporarilyLocked, RetryAfter: 1500 * time.Millisecond}, http.StatusLocked},
+		"ip":      {&auth.LockoutError{Err: auth.ErrTooManyAttempts, RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests},
+	} {
+		basicAuthService := &MockBasicAuthService{
+			SignInFunc: func(email string, password string, ip string) error {
+				if ip != "192.0.2.1" {
+					t.Errorf("expected the client IP, got %q", ip)
+				}
+				return tt.err
+			},
+		}
+		srv := New(&MockSessionService{}, basicAuthService, DefaultConfig())
+
+		req := httptest.NewRequest("POST", "/login", bytes.NewBufferString("email=valid@email.com&password=validPassword"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.loginHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, rr.Code, tt.status)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%s: expected Retry-After to be rounded up to 2, got %q", name, got)
+		}
+	}
+}
+
+func TestLogoutUserHandler_InvalidSession(t *testing.T) {
+	mockSessionService := &MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			return nil, session.ErrExpiredSession
+		},
+		InvalidateSessionFunc: func(sessionId session.SessionID) error {
+			t.Errorf("expected nothing to be invalidated, got %s", sessionId)
+			return nil
+		},
+	}
+	srv := New(mockSessionService, &MockBasicAuthService{}, DefaultConfig())
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "expiredToken"})
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(srv.logoutHandler).ServeHTTP(rec, req)
+
+	if status := rec.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be cleared, got %+v", cookies)
+	}
+}
+
+func TestServer_ShutdownBeforeStart(t *testing.T) {
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	started := make(chan struct{})
+	go func() {
+		srv.Start(0)
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Start to return after Shutdown")
 	}
 }
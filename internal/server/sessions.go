@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// SessionsResponse lists the active sessions of the current user
+type SessionsResponse struct {
+	CurrentSessionId session.SessionID `json:"current_session_id"` // ID of the session making the request
+	Sessions         []session.Session `json:"sessions"`           // Active sessions, most recently used first
+}
+
+// RevokeResponse reports how many sessions were revoked
+type RevokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// listSessionsHandler returns every active session of the current user
+func (s *Server) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.sessionService.ListSessions(current.UserId)
+	if err != nil {
+		http.Error(w, "Unable to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := SessionsResponse{CurrentSessionId: current.Id, Sessions: []session.Session{}}
+	for _, ses := range sessions {
+		response.Sessions = append(response.Sessions, *ses)
+	}
+	writeJSON(w, response)
+}
+
+// revokeSessionHandler revokes one of the current user's sessions by its id
+func (s *Server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.sessionService.RevokeSession(current.UserId, session.SessionID(r.PathValue("id")))
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrInvalidSession):
+			http.Error(w, "session not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Unable to revoke session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOtherSessionsHandler logs the current user out everywhere but the current session
+func (s *Server) revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := s.sessionService.RevokeOtherSessions(current.UserId, current.Id)
+	if err != nil {
+		http.Error(w, "Unable to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, RevokeResponse{Revoked: revoked})
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	responseJSON, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, "Unable to serialize response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+// newSessionsServer returns a server whose current session is "current" owned by "testUser"
+func newSessionsServer(mockSessionService *MockSessionService) *Server {
+	mockSessionService.ValidateSessionFunc = func(token session.Token) (*session.Session, error) {
+		return &session.Session{UserId: "testUser", Id: "current"}, nil
+	}
+	return New(mockSessionService, &MockBasicAuthService{}, DefaultConfig())
+}
+
+func TestListSessionsHandler_Success(t *testing.T) {
+	srv := newSessionsServer(&MockSessionService{
+		ListSessionsFunc: func(userId string) ([]*session.Session, error) {
+			return []*session.Session{{UserId: userId, Id: "current"}, {UserId: userId, Id: "other"}}, nil
+		},
+	})
+
+	req := httptest.NewRequest("GET", "/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "mockToken"})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.listSessionsHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response SessionsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if response.CurrentSessionId != "current" || len(response.Sessions) != 2 {
+		t.Errorf("unexpected response: got %+v", response)
+	}
+}
+
+func TestListSessionsHandler_NoCookie(t *testing.T) {
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+
+	req := httptest.NewRequest("GET", "/sessions", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.listSessionsHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestRevokeSessionHandler(t *testing.T) {
+	srv := newSessionsServer(&MockSessionService{
+		RevokeSessionFunc: func(userId string, sessionId session.SessionID) error {
+			if userId != "testUser" || sessionId != "other" {
+				return session.ErrInvalidSession
+			}
+			return nil
+		},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /sessions/{id}", srv.revokeSessionHandler)
+
+	for id, want := range map[string]int{"other": http.StatusNoContent, "unknown": http.StatusNotFound} {
+		req := httptest.NewRequest("DELETE", "/sessions/"+id, nil)
+		req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "mockToken"})
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("revoking %s returned wrong status code: got %v want %v", id, status, want)
+		}
+	}
+}
+
+func TestRevokeOtherSessionsHandler(t *testing.T) {
+	srv := newSessionsServer(&MockSessionService{
+		RevokeOtherFunc: func(userId string, currentSessionId session.SessionID) (int, error) {
+			if currentSessionId != "current" {
+				t.Errorf("expected the current session to be kept, got %s", currentSessionId)
+			}
+			return 3, nil
+		},
+	})
+
+	req := httptest.NewRequest("DELETE", "/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: "mockToken"})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.revokeOtherSessionsHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var response RevokeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if response.Revoked != 3 {
+		t.Errorf("expected 3 revoked sessions, got %d", response.Revoked)
+	}
+}
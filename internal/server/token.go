@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aloysb/auth-session/middleware"
+	"github.com/aloysb/auth-session/session"
+)
+
+// Value of the login "transport" field asking for the token in the response body
+const bearerTransport = "bearer"
+
+// sessionToken reads the session token of a request, see middleware.TokenFromRequest
+func (s *Server) sessionToken(r *http.Request) (session.Token, error) {
+	return middleware.TokenFromRequest(r, s.config.Cookie.name())
+}
+
+// writeTokenError answers a request whose session token could not be read
+func writeTokenError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// refreshToken reads the refresh token from the refresh_token field for bearer clients,
+// from its cookie otherwise. bearer reports whether it came from the field.
+func (s *Server) refreshToken(r *http.Request) (token session.Token, bearer bool) {
+	if token := session.Token(r.FormValue("refresh_token")); token != "" {
+		return token, true
+	}
+	cookie, err := r.Cookie(s.config.Cookie.refreshName())
+	if err != nil {
+		return "", false
+	}
+	return session.Token(cookie.Value), false
+}
+
+// renewCookie sets a persistent session cookie again with the expiry of its session,
+// which validation may have extended. Otherwise the browser would drop the cookie at
+// the expiry it was given at login, while the session is still valid.
+func (s *Server) renewCookie(w http.ResponseWriter, r *http.Request, token session.Token, expiresAt time.Time) {
+	// Bearer clients keep the token themselves
+	if !s.config.Cookie.Persistent || r.Header.Get("Authorization") != "" {
+		return
+	}
+	http.SetCookie(w, s.config.Cookie.sessionCookie(token, expiresAt))
+}
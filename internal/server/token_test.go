@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aloysb/auth-session/middleware"
+	"github.com/aloysb/auth-session/session"
+)
+
+func TestSessionToken_Precedence(t *testing.T) {
+	srv := New(&MockSessionService{}, &MockBasicAuthService{}, DefaultConfig())
+
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		want          session.Token
+		wantErr       error
+	}{
+		{name: "cookie only", cookie: "cookieToken", want: "cookieToken"},
+		{name: "bearer only", authorization: "Bearer bearerToken", want: "bearerToken"},
+		{name: "bearer wins over cookie", authorization: "Bearer bearerToken", cookie: "cookieToken", want: "bearerToken"},
+		{name: "case insensitive scheme", authorization: "bearer bearerToken", want: "bearerToken"},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", cookie: "cookieToken", wantErr: middleware.ErrMalformedAuthorization},
+		{name: "empty bearer", authorization: "Bearer ", wantErr: middleware.ErrMalformedAuthorization},
+		{name: "nothing", wantErr: middleware.ErrNoToken},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/authenticate", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: tt.cookie})
+		}
+
+		token, err := srv.sessionToken(req)
+		if err != tt.wantErr || token != tt.want {
+			t.Errorf("%s: got (%q, %v), want (%q, %v)", tt.name, token, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateSessionHandler_Bearer(t *testing.T) {
+	mockSessionService := &MockSessionService{
+		ValidateSessionFunc: func(token session.Token) (*session.Session, error) {
+			if token != "bearerToken" {
+				return nil, session.ErrInvalidSession
+			}
+			return &session.Session{UserId: "testUser"}, nil
+		},
+	}
+	srv := New(mockSessionService, &MockBasicAuthService{}, DefaultConfig())
+
+	req := httptest.NewRequest("POST", "/authenticate", nil)
+	req.Header.Set("Authorization", "Bearer bearerToken")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.validateSessionHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if rr.Body.String() != "testUser" {
+		t.Errorf("unexpected response body: got %v want %v", rr.Body.String(), "testUser")
+	}
+}
+
+func TestLoginHandler_BearerTransport(t *testing.T) {
+	mockSessionService := &MockSessionService{
+		CreateSessionFunc: func(userID string, metadata session.Metadata) (*session.Session, session.Token, error) {
+			return &session.Session{UserId: userID}, "mockToken", nil
+		},
+	}
+	basicAuthService := &MockBasicAuthService{
+		SignInFunc: func(email string, password string, ip string) error {
+			return nil
+		},
+	}
+	srv := New(mockSessionService, basicAuthService, DefaultConfig())
+
+	req := httptest.NewRequest("POST", "/login", bytes.NewBufferString("email=valid@email.com&password=validPassword&transport=bearer"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.loginHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response SessionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if response.Token != "mockToken" {
+		t.Errorf("expected the token in the response body, got %q", response.Token)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Errorf("expected no cookie for bearer clients")
+	}
+}
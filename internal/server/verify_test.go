@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aloysb/auth-session/internal/auth"
+	"github.com/aloysb/auth-session/session"
+)
+
+// recordingMailer keeps the emails instead of sending them
+type recordingMailer struct {
+	tokens map[string]string
+	exists []string
+}
+
+func (m *recordingMailer) SendVerification(email, token string) error {
+	m.tokens[email] = token
+	return nil
+}
+
+func (m *recordingMailer) SendAccountExists(email string) error {
+	m.exists = append(m.exists, email)
+	return nil
+}
+
+func newHardenedServer() (http.Handler, *recordingMailer) {
+	sessions := session.NewMemoryStore()
+	mailer := &recordingMailer{tokens: map[string]string{}}
+	authConfig := auth.DefaultConfig()
+	authConfig.Argon2 = auth.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	authConfig.Hardened = true
+	authConfig.Mailer = mailer
+	authService := auth.New(auth.NewMemoryStore(sessions), authConfig)
+
+	serverConfig := DefaultConfig()
+	serverConfig.Hardened = true
+	return New(session.New(sessions, session.DefaultConfig()), authService, serverConfig).Handler(), mailer
+}
+
+func TestHardened_SignUpAndVerify(t *testing.T) {
+	handler, mailer := newHardenedServer()
+	credentials := url.Values{"email": {"new@user.com"}, "password": {"password"}}
+
+	rr := postForm(handler, "/signup", credentials)
+	if rr.Code != http.StatusAccepted || len(rr.Result().Cookies()) != 0 {
+		t.Fatalf("expected a 202 without session, got %d %v", rr.Code, rr.Result().Cookies())
+	}
+	// Until verified, the email looks like any unknown one
+	unverified := postForm(handler, "/login", credentials)
+	unknown := postForm(handler, "/login", url.Values{"email": {"unknown@user.com"}, "password": {"password"}})
+	if unverified.Code != http.StatusUnauthorized || unverified.Body.String() != unknown.Body.String() {
+		t.Errorf("expected an unverified login to fail like an unknown email, got %d %q", unverified.Code, unverified.Body)
+	}
+
+	if rr := postForm(handler, "/verify", url.Values{"token": {"wrong"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid token to be rejected, got %d", rr.Code)
+	}
+	token := mailer.tokens["new@user.com"]
+	if rr := postForm(handler, "/verify", url.Values{"token": {token}}); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the email to be verified, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := postForm(handler, "/login", credentials); rr.Code != http.StatusOK {
+		t.Errorf("expected the verified user to log in, got %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestHardened_NoEnumeration(t *testing.T) {
+	handler, mailer := newHardenedServer()
+	existing := url.Values{"email": {"existing@user.com"}, "password": {"password"}}
+	postForm(handler, "/signup", existing)
+	postForm(handler, "/verify", url.Values{"token": {mailer.tokens["existing@user.com"]}})
+
+	// Signing up again looks the same as a new sign up
+	if rr := postForm(handler, "/signup", existing); rr.Code != http.StatusAccepted {
+		t.Errorf("expected a 202 for an existing email, got %d", rr.Code)
+	}
+	if len(mailer.exists) != 1 {
+		t.Errorf("expected the owner to be told, got %v", mailer.exists)
+	}
+
+	unknown := postForm(handler, "/login", url.Values{"email": {"unknown@user.com"}, "password": {"password"}})
+	wrong := postForm(handler, "/login", url.Values{"email": {"existing@user.com"}, "password": {"wrong"}})
+	if unknown.Code != http.StatusUnauthorized || unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("expected the same answer, got %d %q and %d %q", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+}
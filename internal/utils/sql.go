@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UTCDB binds every time argument in UTC. SQLite stores and compares timestamps as
+// text, so they must all be in the same zone whatever the TZ of the process.
+type UTCDB struct {
+	*sql.DB
+}
+
+func (db UTCDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(query, utc(args)...)
+}
+
+func (db UTCDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(query, utc(args)...)
+}
+
+func (db UTCDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(query, utc(args)...)
+}
+
+// Begin starts a transaction binding its time arguments in UTC as well
+func (db UTCDB) Begin() (UTCTx, error) {
+	tx, err := db.DB.Begin()
+	return UTCTx{tx}, err
+}
+
+// UTCTx is a transaction of a UTCDB
+type UTCTx struct {
+	*sql.Tx
+}
+
+func (tx UTCTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(query, utc(args)...)
+}
+
+func (tx UTCTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(query, utc(args)...)
+}
+
+func (tx UTCTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(query, utc(args)...)
+}
+
+// utc converts the time arguments to UTC, leaving the others as they are
+func utc(args []any) []any {
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case time.Time:
+			args[i] = value.UTC()
+		case sql.NullTime:
+			args[i] = sql.NullTime{Time: value.Time.UTC(), Valid: value.Valid}
+		}
+	}
+	return args
+}
@@ -0,0 +1,182 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A key directory holds one PKCS#8 PEM file per key, named after its id, and a
+// file holding the id of the active key
+const (
+	keyExtension = ".pem"
+	activeFile   = "active"
+)
+
+// Key describes a key of a directory
+type Key struct {
+	Id        string
+	Active    bool
+	CreatedAt time.Time
+}
+
+// Generate adds a new key to the directory and returns its id. The key only verifies
+// tokens until it is activated, except the first key of a directory which is active.
+func Generate(dir string) (string, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("could not generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("could not encode key: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create key directory: %w", err)
+	}
+	kid := KeyID(key.Public().(ed25519.PublicKey))
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := writeFile(filepath.Join(dir, kid+keyExtension), content); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, activeFile)); errors.Is(err, os.ErrNotExist) {
+		return kid, Activate(dir, kid)
+	}
+	return kid, nil
+}
+
+// Activate makes the key sign new tokens, the previous active key keeps verifying theirs
+func Activate(dir, kid string) error {
+	if _, err := os.Stat(filepath.Join(dir, kid+keyExtension)); err != nil {
+		return fmt.Errorf("unknown key %s: %w", kid, err)
+	}
+	return writeFile(filepath.Join(dir, activeFile), []byte(kid+"\n"))
+}
+
+// GenerateSeed returns a new base64 encoded seed, for keys given in the environment
+func GenerateSeed() (string, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return "", fmt.Errorf("could not generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(seed), nil
+}
+
+// List describes the keys of the directory, oldest first
+func List(dir string) ([]Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key directory: %w", err)
+	}
+	active, err := readActive(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Key
+	for _, entry := range entries {
+		kid, ok := strings.CutSuffix(entry.Name(), keyExtension)
+		if entry.IsDir() || !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("could not read key %s: %w", kid, err)
+		}
+		list = append(list, Key{Id: kid, Active: kid == active, CreatedAt: info.ModTime()})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// load reads every key of the directory and the id of the active one
+func load(dir string) (map[string]ed25519.PrivateKey, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read key directory: %w", err)
+	}
+
+	keys := make(map[string]ed25519.PrivateKey)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), keyExtension) {
+			continue
+		}
+		key, err := readKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read key %s: %w", entry.Name(), err)
+		}
+		keys[KeyID(key.Public().(ed25519.PublicKey))] = key
+	}
+
+	active, err := readActive(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, "", fmt.Errorf("active key %q not found in %s", active, dir)
+	}
+	return keys, active, nil
+}
+
+func readKey(path string) (ed25519.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PEM encoded private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+	return key, nil
+}
+
+func readActive(dir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if err != nil {
+		return "", fmt.Errorf("could not read the active key: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// writeFile replaces the file atomically, so that a reload never reads half of it
+func writeFile(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
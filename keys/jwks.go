@@ -0,0 +1,48 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+)
+
+// JWK is an Ed25519 public key in the JSON Web Key format (RFC 8037)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyId     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKS is the set of public keys served at /.well-known/jwks.json. Services verifying
+// tokens on their own can decode it and pass it to session.VerifyToken.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(key ed25519.PublicKey) JWK {
+	return JWK{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(key),
+		KeyId:     KeyID(key),
+		Algorithm: "EdDSA",
+		Use:       "sig",
+	}
+}
+
+// PublicKey returns the key of the set with the given id
+func (s JWKS) PublicKey(kid string) (ed25519.PublicKey, bool) {
+	for _, jwk := range s.Keys {
+		if jwk.KeyId != kid || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, false
+		}
+		return ed25519.PublicKey(key), true
+	}
+	return nil, false
+}
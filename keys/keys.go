@@ -0,0 +1,152 @@
+// Package keys manages the Ed25519 keys signing session tokens. Keys are loaded from
+// a directory or from the environment; one of them is active and signs new tokens,
+// the others are kept to verify the tokens they signed until they are removed.
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a key directory is read again when no interval is configured
+const DefaultReloadInterval = time.Minute
+
+// Manager holds the signing keys. It implements session.Keys.
+type Manager struct {
+	// dir is empty for keys from the environment, which are never reloaded
+	dir string
+
+	mu     sync.RWMutex
+	keys   map[string]ed25519.PrivateKey
+	active string
+}
+
+// FromSeeds loads keys from a comma separated list of base64 encoded 32 bytes
+// Ed25519 seeds. The first key is active, the others only verify tokens.
+func FromSeeds(encoded string) (*Manager, error) {
+	m := &Manager{keys: make(map[string]ed25519.PrivateKey)}
+	for i, seed := range strings.Split(encoded, ",") {
+		key, err := ParseSeed(seed)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		kid := KeyID(key.Public().(ed25519.PublicKey))
+		m.keys[kid] = key
+		if i == 0 {
+			m.active = kid
+		}
+	}
+	return m, nil
+}
+
+// FromDir loads the keys of a directory managed with Generate and Activate
+func FromDir(dir string) (*Manager, error) {
+	m := &Manager{dir: dir}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload reads the key directory again, picking up generated and activated keys.
+// On failure the keys loaded before are kept.
+func (m *Manager) Reload() error {
+	if m.dir == "" {
+		return nil
+	}
+
+	keys, active, err := load(m.dir)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if active != m.active && m.active != "" {
+		slog.Info("Signing key rotated", "kid", active, "previous", m.active)
+	}
+	m.keys = keys
+	m.active = active
+	return nil
+}
+
+// Run reloads the key directory every interval until the context is done
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if m.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				slog.Error("Could not reload signing keys, keeping the current ones", "error", err)
+			}
+		}
+	}
+}
+
+// SigningKey returns the active key and its id
+func (m *Manager) SigningKey() (string, ed25519.PrivateKey) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active, m.keys[m.active]
+}
+
+// PublicKey returns the public half of a known key
+func (m *Manager) PublicKey(kid string) (ed25519.PublicKey, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, false
+	}
+	return key.Public().(ed25519.PublicKey), true
+}
+
+// JWKS returns the public keys, the active one first
+func (m *Manager) JWKS() JWKS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{newJWK(m.keys[m.active].Public().(ed25519.PublicKey))}}
+	for kid, key := range m.keys {
+		if kid != m.active {
+			set.Keys = append(set.Keys, newJWK(key.Public().(ed25519.PublicKey)))
+		}
+	}
+	return set
+}
+
+// ParseSeed reads an Ed25519 private key from its base64 encoded 32 bytes seed
+func ParseSeed(encoded string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("signing key must be a 32 bytes Ed25519 seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// KeyID identifies a key by its RFC 7638 JWK thumbprint
+func KeyID(key ed25519.PublicKey) string {
+	// Members in lexicographic order, without whitespace
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(key) + `"}`))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
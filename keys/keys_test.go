@@ -0,0 +1,159 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+func TestKeyID(t *testing.T) {
+	// RFC 8037, appendix A.3
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if got := KeyID(ed25519.PublicKey(x)); got != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("unexpected thumbprint: %s", got)
+	}
+}
+
+func TestFromSeeds(t *testing.T) {
+	first, _ := GenerateSeed()
+	second, _ := GenerateSeed()
+
+	m, err := FromSeeds(first + "," + second)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	firstKey, _ := ParseSeed(first)
+	secondKey, _ := ParseSeed(second)
+	kid, key := m.SigningKey()
+	if kid != KeyID(firstKey.Public().(ed25519.PublicKey)) || !key.Equal(firstKey) {
+		t.Errorf("expected the first key to be active, got %s", kid)
+	}
+	if _, ok := m.PublicKey(KeyID(secondKey.Public().(ed25519.PublicKey))); !ok {
+		t.Errorf("expected the second key to verify tokens")
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short")), first + ","} {
+		if _, err := FromSeeds(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestDirRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	firstId, err := Generate(dir)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	m, err := FromDir(dir)
+	if err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+	if kid, _ := m.SigningKey(); kid != firstId {
+		t.Fatalf("expected the first key to be active, got %s", kid)
+	}
+	token := signToken(t, m)
+
+	// A generated key is published before it signs anything
+	secondId, err := Generate(dir)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("failed to reload keys: %v", err)
+	}
+	if kid, _ := m.SigningKey(); kid != firstId {
+		t.Errorf("expected the first key to stay active, got %s", kid)
+	}
+	if _, ok := m.PublicKey(secondId); !ok {
+		t.Errorf("expected the new key to be published")
+	}
+
+	if err := Activate(dir, secondId); err != nil {
+		t.Fatalf("failed to activate key: %v", err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("failed to reload keys: %v", err)
+	}
+	if kid, _ := m.SigningKey(); kid != secondId {
+		t.Errorf("expected the new key to be active, got %s", kid)
+	}
+	if _, err := session.VerifyToken(token, m, time.Now()); err != nil {
+		t.Errorf("expected tokens of the previous key to verify, got %v", err)
+	}
+
+	list, err := List(dir)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("List: got (%+v, %v)", list, err)
+	}
+	for _, key := range list {
+		if key.Active != (key.Id == secondId) {
+			t.Errorf("unexpected active flag: %+v", key)
+		}
+	}
+
+	if err := Activate(dir, "unknown"); err == nil {
+		t.Errorf("expected an error activating an unknown key")
+	}
+
+	// A broken directory does not lose the loaded keys
+	os.WriteFile(filepath.Join(dir, "broken"+keyExtension), []byte("garbage"), 0o600)
+	if err := m.Reload(); err == nil {
+		t.Errorf("expected an error reloading a broken directory")
+	}
+	if kid, _ := m.SigningKey(); kid != secondId {
+		t.Errorf("expected the loaded keys to be kept, got %s", kid)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	first, _ := GenerateSeed()
+	second, _ := GenerateSeed()
+	m, _ := FromSeeds(first + "," + second)
+	token := signToken(t, m)
+
+	data, err := json.Marshal(m.JWKS())
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("failed to decode JWKS: %v", err)
+	}
+	activeId, _ := m.SigningKey()
+	if len(set.Keys) != 2 || set.Keys[0].KeyId != activeId {
+		t.Fatalf("expected both keys, the active one first, got %+v", set.Keys)
+	}
+	if key := set.Keys[0]; key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != "EdDSA" {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	// Services verifying tokens on their own only need the published set
+	if _, err := session.VerifyToken(token, set, time.Now()); err != nil {
+		t.Errorf("expected the token to verify with the JWKS, got %v", err)
+	}
+}
+
+func signToken(t *testing.T, keys session.Keys) session.Token {
+	now := time.Now()
+	kid, key := keys.SigningKey()
+	token, err := session.SignToken(session.Claims{
+		Subject:   "user123",
+		SessionId: "sessionId",
+		IssuedAt:  session.NumericDate{Time: now},
+		ExpiresAt: session.NumericDate{Time: now.Add(time.Hour)},
+	}, kid, key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
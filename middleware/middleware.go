@@ -0,0 +1,111 @@
+// Package middleware authenticates requests of Go services linking this library,
+// validating the session in process instead of calling the /authenticate endpoint.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+type contextKey struct{}
+
+// Options customises the middleware
+type Options struct {
+	// CookieName is the name of the session cookie, defaults to session.COOKIE_NAME
+	CookieName string
+	// Unauthorized writes the response to a request rejected with err, defaults to DefaultUnauthorized
+	Unauthorized func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type Middleware struct {
+	sessions session.ISessionService
+	options  Options
+}
+
+func New(sessions session.ISessionService, options Options) *Middleware {
+	if options.CookieName == "" {
+		options.CookieName = session.COOKIE_NAME
+	}
+	if options.Unauthorized == nil {
+		options.Unauthorized = DefaultUnauthorized
+	}
+	return &Middleware{
+		sessions: sessions,
+		options:  options,
+	}
+}
+
+// RequireSession only lets requests with a valid session through, the session
+// is available to next through SessionFromContext
+func (m *Middleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ses, err := m.authenticate(r)
+		if err != nil {
+			m.options.Unauthorized(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), ses)))
+	})
+}
+
+// OptionalSession lets every request through, with the session in the context
+// when there is a valid one. Errors other than a missing, invalid or expired
+// session are still rejected.
+func (m *Middleware) OptionalSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ses, err := m.authenticate(r)
+		switch {
+		case err == nil:
+			r = r.WithContext(WithSession(r.Context(), ses))
+		case !anonymous(err):
+			m.options.Unauthorized(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) authenticate(r *http.Request) (*session.Session, error) {
+	token, err := TokenFromRequest(r, m.options.CookieName)
+	if err != nil {
+		return nil, err
+	}
+	return m.sessions.ValidateSession(token)
+}
+
+// SessionFromContext returns the session stored by the middleware
+func SessionFromContext(ctx context.Context) (*session.Session, bool) {
+	ses, ok := ctx.Value(contextKey{}).(*session.Session)
+	return ses, ok && ses != nil
+}
+
+// WithSession stores a session in the context, for SessionFromContext to find.
+// It is mostly useful to test handlers without the middleware.
+func WithSession(ctx context.Context, ses *session.Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, ses)
+}
+
+// DefaultUnauthorized answers 400 to a malformed Authorization header, 401 to a
+// missing, invalid or expired session and 500 to anything else
+func DefaultUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrMalformedAuthorization):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case anonymous(err):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	default:
+		slog.Error("Error validating session", "error", err)
+		http.Error(w, "Error validating session", http.StatusInternalServerError)
+	}
+}
+
+// anonymous reports whether the error means the request simply has no usable session
+func anonymous(err error) bool {
+	return errors.Is(err, ErrNoToken) ||
+		errors.Is(err, session.ErrInvalidSession) ||
+		errors.Is(err, session.ErrExpiredSession)
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+func setupMiddleware(t *testing.T, options Options) (*Middleware, session.Token) {
+	service := session.New(session.NewMemoryStore(), session.DefaultConfig())
+	_, token, err := service.CreateSession("user@example.com", session.Metadata{})
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	return New(service, options), token
+}
+
+// echoUser writes the user of the session in the context, or "anonymous"
+var echoUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	ses, ok := SessionFromContext(r.Context())
+	if !ok {
+		w.Write([]byte("anonymous"))
+		return
+	}
+	w.Write([]byte(ses.UserId))
+})
+
+func serve(handler http.Handler, configure func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	configure(req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRequireSession(t *testing.T) {
+	m, token := setupMiddleware(t, Options{})
+	handler := m.RequireSession(echoUser)
+
+	tests := []struct {
+		name      string
+		configure func(r *http.Request)
+		code      int
+		body      string
+	}{
+		{
+			name:      "cookie",
+			configure: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: session.COOKIE_NAME, Value: string(token)}) },
+			code:      http.StatusOK,
+			body:      "user@example.com",
+		},
+		{
+			name:      "bearer",
+			configure: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+string(token)) },
+			code:      http.StatusOK,
+			body:      "user@example.com",
+		},
+		{
+			name:      "no token",
+			configure: func(r *http.Request) {},
+			code:      http.StatusUnauthorized,
+		},
+		{
+			name:      "invalid token",
+			configure: func(r *http.Request) { r.Header.Set("Authorization", "Bearer invalid") },
+			code:      http.StatusUnauthorized,
+		},
+		{
+			name:      "malformed header",
+			configure: func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") },
+			code:      http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		rr := serve(handler, tt.configure)
+		if rr.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.code)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, rr.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRequireSession_CustomUnauthorized(t *testing.T) {
+	var gotErr error
+	m, _ := setupMiddleware(t, Options{
+		CookieName: "custom",
+		Unauthorized: func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			http.Redirect(w, r, "/login", http.StatusFound)
+		},
+	})
+
+	rr := serve(m.RequireSession(echoUser), func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "custom", Value: "invalid"})
+	})
+
+	if rr.Code != http.StatusFound {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusFound)
+	}
+	if !errors.Is(gotErr, session.ErrInvalidSession) {
+		t.Errorf("hook got error %v, want %v", gotErr, session.ErrInvalidSession)
+	}
+}
+
+func TestOptionalSession(t *testing.T) {
+	m, token := setupMiddleware(t, Options{})
+	handler := m.OptionalSession(echoUser)
+
+	rr := serve(handler, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+string(token)) })
+	if rr.Code != http.StatusOK || rr.Body.String() != "user@example.com" {
+		t.Errorf("valid session: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(handler, func(r *http.Request) {})
+	if rr.Code != http.StatusOK || rr.Body.String() != "anonymous" {
+		t.Errorf("no token: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(handler, func(r *http.Request) { r.Header.Set("Authorization", "Bearer invalid") })
+	if rr.Code != http.StatusOK || rr.Body.String() != "anonymous" {
+		t.Errorf("invalid token: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(handler, func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") })
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("malformed header: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSessionFromContext_Empty(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if ses, ok := SessionFromContext(req.Context()); ok || ses != nil {
+		t.Errorf("expected no session, got %v", ses)
+	}
+}
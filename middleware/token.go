@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aloysb/auth-session/session"
+)
+
+var (
+	ErrNoToken                = errors.New("session token not found")
+	ErrMalformedAuthorization = errors.New("malformed Authorization header, expected: Bearer <token>")
+)
+
+// TokenFromRequest reads the session token of a request. An Authorization header
+// takes precedence over the cookie: when present, the cookie is ignored.
+func TokenFromRequest(r *http.Request, cookieName string) (session.Token, error) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		token = strings.TrimSpace(token)
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", ErrMalformedAuthorization
+		}
+		return session.Token(token), nil
+	}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return "", ErrNoToken
+	}
+	return session.Token(cookie.Value), nil
+}
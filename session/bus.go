@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// How long publishing an invalidation may take before it is given up
+const publishTimeout = 5 * time.Second
+
+// Invalidation describes sessions revoked by one process, for the others to drop from their cache
+type Invalidation struct {
+	SessionId SessionID `json:"session_id,omitempty"` // A single revoked session
+	UserId    string    `json:"user_id,omitempty"`    // Every session of the user was revoked...
+	ExceptId  SessionID `json:"except_id,omitempty"`  // ...except this one
+	All       bool      `json:"all,omitempty"`        // Anything may have been revoked, e.g. after missing messages
+}
+
+// InvalidationBus broadcasts invalidations between the processes sharing a database
+type InvalidationBus interface {
+	// Publish sends the invalidation to every listening process, including this one
+	Publish(ctx context.Context, invalidation Invalidation) error
+	// Listen calls handler for every invalidation published, until the context is done
+	Listen(ctx context.Context, handler func(Invalidation)) error
+}
+
+// NopBus is the bus of a single process deployment, e.g. on SQLite: it drops everything
+type NopBus struct{}
+
+func (NopBus) Publish(ctx context.Context, invalidation Invalidation) error {
+	return nil
+}
+
+func (NopBus) Listen(ctx context.Context, handler func(Invalidation)) error {
+	<-ctx.Done()
+	return nil
+}
+
+// NotifyBus returns a RevocationListener publishing the revocations reported by the
+// auth service, which deletes sessions without going through the session service
+func NotifyBus(bus InvalidationBus) RevocationListener {
+	return busNotifier{bus}
+}
+
+type busNotifier struct {
+	bus InvalidationBus
+}
+
+func (n busNotifier) SessionsRevoked(userId string, exceptId SessionID) {
+	publish(n.bus, Invalidation{UserId: userId, ExceptId: exceptId})
+}
+
+// publish sends the invalidation, the revocation itself is already stored so a failure
+// is only logged: other processes will see it once their cache TTL runs out
+func publish(bus InvalidationBus, invalidation Invalidation) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := bus.Publish(ctx, invalidation); err != nil {
+		slog.Error("could not publish session invalidation", "error", err)
+	}
+}
+
+// Publisher publishes the revocations made through a session service to the bus, so
+// that the other processes drop them from their cache. Wrap it around the service
+// whether or not this process caches sessions, around the cache when it does.
+type Publisher struct {
+	ISessionService
+	bus InvalidationBus
+}
+
+func NewPublisher(service ISessionService, bus InvalidationBus) *Publisher {
+	return &Publisher{ISessionService: service, bus: bus}
+}
+
+// InvalidateSession invalidates the session and publishes it
+func (p *Publisher) InvalidateSession(sessionId SessionID) error {
+	defer publish(p.bus, Invalidation{SessionId: sessionId})
+	return p.ISessionService.InvalidateSession(sessionId)
+}
+
+// RevokeSession revokes the session and publishes it
+func (p *Publisher) RevokeSession(userId string, sessionId SessionID) error {
+	defer publish(p.bus, Invalidation{SessionId: sessionId})
+	return p.ISessionService.RevokeSession(userId, sessionId)
+}
+
+// RevokeOtherSessions revokes the other sessions of the user and publishes them
+func (p *Publisher) RevokeOtherSessions(userId string, currentSessionId SessionID) (int, error) {
+	defer publish(p.bus, Invalidation{UserId: userId, ExceptId: currentSessionId})
+	return p.ISessionService.RevokeOtherSessions(userId, currentSessionId)
+}
+
+// Refresh exchanges the refresh token and publishes the session it replaced, or the
+// session of the family revoked when the token was reused
+func (p *Publisher) Refresh(refreshToken Token, metadata Metadata) (*Refreshed, error) {
+	refreshed, err := p.ISessionService.Refresh(refreshToken, metadata)
+	if invalidation, ok := refreshInvalidation(refreshed, err); ok {
+		publish(p.bus, invalidation)
+	}
+	return refreshed, err
+}
+
+// RevokeRefreshToken revokes the family of the refresh token and publishes its session
+func (p *Publisher) RevokeRefreshToken(refreshToken Token) (SessionID, error) {
+	sessionId, err := p.ISessionService.RevokeRefreshToken(refreshToken)
+	if err == nil {
+		publish(p.bus, Invalidation{SessionId: sessionId})
+	}
+	return sessionId, err
+}
+
+// refreshInvalidation returns the session a refresh ended: the one replaced, or the
+// one of the family revoked by a reused token
+func refreshInvalidation(refreshed *Refreshed, err error) (Invalidation, bool) {
+	var reused *ReuseError
+	switch {
+	case err == nil:
+		return Invalidation{SessionId: refreshed.Replaced}, true
+	case errors.As(err, &reused):
+		return Invalidation{SessionId: reused.SessionId}, true
+	}
+	return Invalidation{}, false
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBus keeps the published invalidations
+type recordingBus struct {
+	mu        sync.Mutex
+	published []Invalidation
+}
+
+func (b *recordingBus) Publish(ctx context.Context, invalidation Invalidation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, invalidation)
+	return nil
+}
+
+func (b *recordingBus) Listen(ctx context.Context, handler func(Invalidation)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestPublisher_PublishesRevocations(t *testing.T) {
+	bus := &recordingBus{}
+	service, _ := setupRefreshService()
+	// Without a cache, as in the default setup
+	publisher := NewPublisher(service, bus)
+
+	created, _, _ := publisher.CreateSession("user123", Metadata{})
+	publisher.InvalidateSession(created.Id)
+	revoked, _, _ := publisher.CreateSession("user123", Metadata{})
+	publisher.RevokeSession("user123", revoked.Id)
+	publisher.RevokeOtherSessions("user123", "current")
+
+	refreshed, _, _ := publisher.CreateSession("user123", Metadata{})
+	refreshToken, _, _ := publisher.IssueRefreshToken(refreshed)
+	next, err := publisher.Refresh(refreshToken, Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := publisher.RevokeRefreshToken(next.RefreshToken); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := []Invalidation{
+		{SessionId: created.Id},
+		{SessionId: revoked.Id},
+		{UserId: "user123", ExceptId: "current"},
+		{SessionId: refreshed.Id},
+		{SessionId: next.Session.Id},
+	}
+	if len(bus.published) != len(want) {
+		t.Fatalf("expected %d invalidations, got %+v", len(want), bus.published)
+	}
+	for i := range want {
+		if bus.published[i] != want[i] {
+			t.Errorf("invalidation %d: got %+v, want %+v", i, bus.published[i], want[i])
+		}
+	}
+}
+
+func TestCache_Apply(t *testing.T) {
+	service, store := setupService()
+	cache := NewCache(service, CacheConfig{TTL: time.Minute})
+
+	validated := func(userId string) (Token, *Session) {
+		session, token, _ := cache.CreateSession(userId, Metadata{})
+		if _, err := cache.ValidateSession(token); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return token, session
+	}
+	cached := func(session *Session) bool {
+		_, _, ok := cache.get(session.Id, time.Now())
+		return ok
+	}
+
+	// Revoked by another replica
+	_, first := validated("user123")
+	_, second := validated("user123")
+	_, third := validated("user456")
+	store.Delete(first.Id)
+
+	cache.Apply(Invalidation{SessionId: first.Id})
+	if cached(first) || !cached(second) {
+		t.Errorf("expected only the invalidated session to be dropped")
+	}
+
+	cache.Apply(Invalidation{UserId: "user123"})
+	if cached(second) || !cached(third) {
+		t.Errorf("expected only the user's sessions to be dropped")
+	}
+
+	cache.Apply(Invalidation{All: true})
+	if cached(third) || cache.Stats().Size != 0 {
+		t.Errorf("expected every session to be dropped")
+	}
+}
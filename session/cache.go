@@ -0,0 +1,221 @@
+package session
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default cache settings
+const (
+	DefaultCacheTTL  = 5 * time.Second
+	DefaultCacheSize = 10_000
+)
+
+// CacheConfig bounds how long and how many validated sessions are cached
+type CacheConfig struct {
+	// TTL is how long a validation result is reused. Revocations made in another
+	// process are only seen once it runs out, keep it short.
+	TTL time.Duration
+	// Size is the maximum number of cached sessions, the least recently used are evicted first
+	Size int
+}
+
+// CacheStats counts the validations served from the cache and from the wrapped service
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Size   int
+}
+
+// Cache is an in-process LRU cache of validated sessions in front of a session service.
+// Sessions invalidated or revoked through it, or reported to SessionsRevoked, are dropped
+// immediately; pass the invalidations received from the bus to Apply. Publishing the
+// revocations to the other processes is left to a Publisher wrapped around the cache.
+type Cache struct {
+	ISessionService
+	config CacheConfig
+
+	mu sync.Mutex
+	// entries are keyed by the hash of the token, which is the session id of opaque tokens only
+	entries map[SessionID]*list.Element
+	lru     *list.List
+	// generation changes on every eviction, so that a validation racing with a
+	// revocation does not put the revoked session back
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key      SessionID
+	session  Session
+	cachedAt time.Time
+}
+
+func NewCache(service ISessionService, config CacheConfig) *Cache {
+	if config.TTL <= 0 {
+		config.TTL = DefaultCacheTTL
+	}
+	if config.Size <= 0 {
+		config.Size = DefaultCacheSize
+	}
+	return &Cache{
+		ISessionService: service,
+		config:          config,
+		entries:         make(map[SessionID]*list.Element),
+		lru:             list.New(),
+	}
+}
+
+// ValidateSession returns the cached session when it was validated less than a TTL ago,
+// and asks the wrapped service otherwise
+func (c *Cache) ValidateSession(token Token) (*Session, error) {
+	key := token.SessionID()
+	now := time.Now()
+
+	session, generation, ok := c.get(key, now)
+	if ok {
+		c.hits.Add(1)
+		return session, nil
+	}
+	c.misses.Add(1)
+
+	session, err := c.ISessionService.ValidateSession(token)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, session, generation, now)
+	return session, nil
+}
+
+// InvalidateSession invalidates the session and drops it from the cache
+func (c *Cache) InvalidateSession(sessionId SessionID) error {
+	defer c.Apply(Invalidation{SessionId: sessionId})
+	return c.ISessionService.InvalidateSession(sessionId)
+}
+
+// RevokeSession revokes the session and drops it from the cache
+func (c *Cache) RevokeSession(userId string, sessionId SessionID) error {
+	defer c.Apply(Invalidation{SessionId: sessionId})
+	return c.ISessionService.RevokeSession(userId, sessionId)
+}
+
+// RevokeOtherSessions revokes the other sessions of the user and drops them from the cache
+func (c *Cache) RevokeOtherSessions(userId string, currentSessionId SessionID) (int, error) {
+	defer c.Apply(Invalidation{UserId: userId, ExceptId: currentSessionId})
+	return c.ISessionService.RevokeOtherSessions(userId, currentSessionId)
+}
+
+// Refresh exchanges the refresh token and drops the session it replaced from the cache.
+// A reused token drops the session of its revoked family.
+func (c *Cache) Refresh(refreshToken Token, metadata Metadata) (*Refreshed, error) {
+	refreshed, err := c.ISessionService.Refresh(refreshToken, metadata)
+	if invalidation, ok := refreshInvalidation(refreshed, err); ok {
+		c.Apply(invalidation)
+	}
+	return refreshed, err
+}
+
+// RevokeRefreshToken revokes the family of the refresh token and drops its session from the cache
+func (c *Cache) RevokeRefreshToken(refreshToken Token) (SessionID, error) {
+	sessionId, err := c.ISessionService.RevokeRefreshToken(refreshToken)
+	if err == nil {
+		c.Apply(Invalidation{SessionId: sessionId})
+	}
+	return sessionId, err
+}
+
+// SessionsRevoked drops every cached session of the user except exceptId. The revocation
+// is not published, the service revoking the sessions reports it to NotifyBus.
+func (c *Cache) SessionsRevoked(userId string, exceptId SessionID) {
+	c.Apply(Invalidation{UserId: userId, ExceptId: exceptId})
+}
+
+// Apply drops the sessions described by an invalidation
+func (c *Cache) Apply(invalidation Invalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	switch {
+	case invalidation.All:
+		c.entries = make(map[SessionID]*list.Element)
+		c.lru.Init()
+	case invalidation.SessionId != "":
+		for _, element := range c.entries {
+			if element.Value.(*cacheEntry).session.Id == invalidation.SessionId {
+				c.remove(element)
+			}
+		}
+	case invalidation.UserId != "":
+		for _, element := range c.entries {
+			session := element.Value.(*cacheEntry).session
+			if session.Id != invalidation.ExceptId && session.UserId == invalidation.UserId {
+				c.remove(element)
+			}
+		}
+	}
+}
+
+// Stats returns the hit and miss counters and the current size of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+}
+
+// get returns a copy of the cached session if it is still fresh, along with the
+// generation to pass to put on a miss
+func (c *Cache) get(key SessionID, now time.Time) (*Session, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if now.Sub(entry.cachedAt) >= c.config.TTL || now.After(entry.session.ExpiresAt) {
+		c.remove(element)
+		return nil, c.generation, false
+	}
+
+	c.lru.MoveToFront(element)
+	session := entry.session
+	return &session, c.generation, true
+}
+
+func (c *Cache) put(key SessionID, session *Session, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Something was evicted while the session was being validated, it may have been this one
+	if generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, session: *session, cachedAt: now})
+
+	for c.lru.Len() > c.config.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry, the lock must be held
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
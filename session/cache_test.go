@@ -0,0 +1,181 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupCache(config CacheConfig) (*Cache, *SessionService, *MemoryStore) {
+	service, store := setupService()
+	return NewCache(service, config), service, store
+}
+
+func TestCache_HitsAndMisses(t *testing.T) {
+	cache, _, store := setupCache(CacheConfig{TTL: time.Minute})
+
+	created, token, err := cache.CreateSession("user123", Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		session, err := cache.ValidateSession(token)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if session.Id != created.Id {
+			t.Errorf("expected session %s, got %s", created.Id, session.Id)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.Size != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Hits are served without reading the store
+	store.Delete(created.Id)
+	if _, err := cache.ValidateSession(token); err != nil {
+		t.Errorf("expected a cache hit, got %v", err)
+	}
+
+	if _, err := cache.ValidateSession("unknown"); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	cache, _, store := setupCache(CacheConfig{TTL: 10 * time.Millisecond})
+
+	created, token, _ := cache.CreateSession("user123", Metadata{})
+	if _, err := cache.ValidateSession(token); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	store.Delete(created.Id)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("expected the stale entry to be revalidated, got %v", err)
+	}
+}
+
+func TestCache_Size(t *testing.T) {
+	cache, _, _ := setupCache(CacheConfig{TTL: time.Minute, Size: 2})
+
+	var tokens []Token
+	for i := 0; i < 3; i++ {
+		_, token, _ := cache.CreateSession("user123", Metadata{})
+		tokens = append(tokens, token)
+	}
+
+	cache.ValidateSession(tokens[0])
+	cache.ValidateSession(tokens[1])
+	cache.ValidateSession(tokens[0]) // tokens[1] is now the least recently used
+	cache.ValidateSession(tokens[2])
+
+	if stats := cache.Stats(); stats.Size != 2 {
+		t.Fatalf("expected the cache to be bounded to 2 sessions, got %d", stats.Size)
+	}
+	if _, _, ok := cache.get(tokens[1].SessionID(), time.Now()); ok {
+		t.Errorf("expected the least recently used session to be evicted")
+	}
+	if _, _, ok := cache.get(tokens[0].SessionID(), time.Now()); !ok {
+		t.Errorf("expected the recently used session to be kept")
+	}
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	cache, _, _ := setupCache(CacheConfig{TTL: time.Minute})
+
+	newSession := func(userId string) (Token, *Session) {
+		session, token, err := cache.CreateSession(userId, Metadata{})
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		if _, err := cache.ValidateSession(token); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return token, session
+	}
+
+	token, session := newSession("user123")
+	if err := cache.InvalidateSession(session.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("invalidated session: expected ErrInvalidSession, got %v", err)
+	}
+
+	token, session = newSession("user123")
+	if err := cache.RevokeSession("user123", session.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("revoked session: expected ErrInvalidSession, got %v", err)
+	}
+
+	currentToken, current := newSession("user123")
+	otherToken, _ := newSession("user123")
+	someoneElseToken, _ := newSession("user456")
+	if _, err := cache.RevokeOtherSessions("user123", current.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(otherToken); err != ErrInvalidSession {
+		t.Errorf("other session: expected ErrInvalidSession, got %v", err)
+	}
+	if _, err := cache.ValidateSession(currentToken); err != nil {
+		t.Errorf("current session: expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(someoneElseToken); err != nil {
+		t.Errorf("someone else's session: expected no error, got %v", err)
+	}
+}
+
+func TestCache_SessionsRevoked(t *testing.T) {
+	cache, _, store := setupCache(CacheConfig{TTL: time.Minute})
+
+	_, token, _ := cache.CreateSession("user123", Metadata{})
+	if _, err := cache.ValidateSession(token); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Revoked behind the cache's back, e.g. along with a password change
+	store.DeleteByUser("user123", "")
+	cache.SessionsRevoked("user123", "")
+
+	if _, err := cache.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+}
+
+func TestCache_Refresh(t *testing.T) {
+	service, _ := setupRefreshService()
+	cache := NewCache(service, CacheConfig{TTL: time.Minute})
+
+	session, token, _ := cache.CreateSession("user123", Metadata{})
+	refreshToken, _, err := cache.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(token); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	refreshed, err := cache.Refresh(refreshToken, Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("expected the replaced session to be dropped from the cache, got %v", err)
+	}
+
+	// The session of a reused family is dropped too
+	if _, err := cache.ValidateSession(refreshed.Token); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cache.Refresh(refreshToken, Metadata{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := cache.ValidateSession(refreshed.Token); err != ErrInvalidSession {
+		t.Errorf("expected the revoked session to be dropped from the cache, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// How often the memory store sweeps out expired sessions
+const memoryEvictionInterval = time.Minute
+
+// MemoryStore is a concurrency-safe SessionStore keeping sessions in process memory.
+// Expired sessions are evicted lazily, at most once per eviction interval, on writes.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	sessions     map[SessionID]Session
+	revocations  []Revocation
+	families     map[string]TokenFamily
+	refresh      map[string]RefreshToken
+	lastEviction time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:     make(map[SessionID]Session),
+		families:     make(map[string]TokenFamily),
+		refresh:      make(map[string]RefreshToken),
+		lastEviction: time.Now(),
+	}
+}
+
+func (m *MemoryStore) Create(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evictExpiredLocked(time.Now())
+	m.sessions[session.Id] = *session
+	return nil
+}
+
+func (m *MemoryStore) Get(id SessionID) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (m *MemoryStore) Touch(id SessionID, lastSeenAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evictExpiredLocked(time.Now())
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+	session.LastSeenAt = lastSeenAt
+	session.ExpiresAt = expiresAt
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *MemoryStore) Delete(id SessionID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) ListByUser(userId string, now time.Time) ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range m.sessions {
+		if session.UserId == userId && session.ExpiresAt.After(now) {
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryStore) DeleteByUser(userId string, exceptId SessionID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, session := range m.sessions {
+		if session.UserId == userId && id != exceptId {
+			delete(m.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemoryStore) DeleteExpired(before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, session := range m.sessions {
+		if removed >= limit {
+			break
+		}
+		if session.ExpiresAt.Before(before) {
+			delete(m.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemoryStore) Revoke(revocation Revocation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revocations = append(m.revocations, revocation)
+	return nil
+}
+
+func (m *MemoryStore) Revoked(sessionId SessionID, userId string, issuedAt, now time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, revocation := range m.revocations {
+		if revocation.covers(sessionId, userId, issuedAt, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) DeleteExpiredRevocations(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.revocations[:0]
+	for _, revocation := range m.revocations {
+		if !revocation.ExpiresAt.Before(before) {
+			kept = append(kept, revocation)
+		}
+	}
+	removed := len(m.revocations) - len(kept)
+	m.revocations = kept
+	return removed, nil
+}
+
+func (m *MemoryStore) CreateTokenFamily(family *TokenFamily, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.families[family.Id] = *family
+	m.refresh[token.Id] = *token
+	return nil
+}
+
+func (m *MemoryStore) GetTokenFamily(id string) (*TokenFamily, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	family, ok := m.families[id]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return &family, nil
+}
+
+func (m *MemoryStore) GetRefreshToken(id string) (*RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refresh[id]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return &token, nil
+}
+
+func (m *MemoryStore) UseRefreshToken(id string, usedAt time.Time) (*RefreshToken, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refresh[id]
+	if !ok {
+		return nil, false, ErrRefreshTokenNotFound
+	}
+	if !token.UsedAt.IsZero() {
+		return &token, false, nil
+	}
+	token.UsedAt = usedAt
+	m.refresh[id] = token
+	return &token, true, nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(familyId string, sessionId SessionID, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family, ok := m.families[familyId]
+	if !ok || !family.RevokedAt.IsZero() {
+		return ErrRefreshTokenNotFound
+	}
+	m.refresh[token.Id] = *token
+	family.SessionId = sessionId
+	family.ExpiresAt = token.ExpiresAt
+	m.families[familyId] = family
+	return nil
+}
+
+func (m *MemoryStore) RevokeTokenFamily(id string, revokedAt time.Time) (SessionID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family, ok := m.families[id]
+	if !ok {
+		return "", ErrRefreshTokenNotFound
+	}
+	if family.RevokedAt.IsZero() {
+		family.RevokedAt = revokedAt
+		m.families[id] = family
+	}
+	delete(m.sessions, family.SessionId)
+	return family.SessionId, nil
+}
+
+func (m *MemoryStore) RevokeTokenFamilies(revocation Revocation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revoked := 0
+	for id, family := range m.families {
+		if !family.RevokedAt.IsZero() {
+			continue
+		}
+		if family.SessionId == revocation.SessionId || (family.UserId == revocation.UserId && family.SessionId != revocation.ExceptId) {
+			family.RevokedAt = revocation.RevokedAt
+			m.families[id] = family
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MemoryStore) DeleteExpiredTokenFamilies(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, family := range m.families {
+		if family.ExpiresAt.Before(before) {
+			delete(m.families, id)
+			removed++
+		}
+	}
+	for id, token := range m.refresh {
+		if _, ok := m.families[token.FamilyId]; !ok || token.ExpiresAt.Before(before) {
+			delete(m.refresh, id)
+		}
+	}
+	return removed, nil
+}
+
+// evictExpiredLocked drops expired sessions, the caller must hold the write lock
+func (m *MemoryStore) evictExpiredLocked(now time.Time) {
+	if now.Sub(m.lastEviction) < memoryEvictionInterval {
+		return
+	}
+	m.lastEviction = now
+
+	for id, session := range m.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres channel the invalidations are sent on
+const InvalidationChannel = "auth_session_invalidations"
+
+// Reconnection backoff of the listener, and how often an idle connection is checked
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// PostgresBus broadcasts invalidations with LISTEN/NOTIFY
+type PostgresBus struct {
+	db  *sql.DB
+	url string
+}
+
+// NewPostgresBus publishes through db, and listens on a dedicated connection to url
+func NewPostgresBus(db *sql.DB, url string) *PostgresBus {
+	return &PostgresBus{db: db, url: url}
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, invalidation Invalidation) error {
+	payload, err := json.Marshal(invalidation)
+	if err != nil {
+		return fmt.Errorf("could not encode invalidation: %w", err)
+	}
+
+	if _, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", InvalidationChannel, string(payload)); err != nil {
+		return fmt.Errorf("could not notify invalidation: %w", err)
+	}
+	return nil
+}
+
+// Listen reconnects on its own when the connection drops. Notifications sent in the
+// meantime are lost, so the handler is then told that anything may have been revoked.
+func (b *PostgresBus) Listen(ctx context.Context, handler func(Invalidation)) error {
+	listener := pq.NewListener(b.url, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("session invalidation listener", "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(InvalidationChannel); err != nil {
+		return fmt.Errorf("could not listen for invalidations: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// A nil notification means the connection was re-established
+			if notification == nil {
+				handler(Invalidation{All: true})
+				continue
+			}
+
+			var invalidation Invalidation
+			if err := json.Unmarshal([]byte(notification.Extra), &invalidation); err != nil {
+				slog.Error("invalid session invalidation", "error", err)
+				continue
+			}
+			handler(invalidation)
+		case <-time.After(listenerPingInterval):
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Error constants for refresh tokens
+var (
+	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token reused")
+	ErrRefreshTokensDisabled = errors.New("refresh tokens disabled")
+)
+
+// ErrRefreshTokenNotFound is returned by a SessionStore when no refresh token or family matches the id
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// TokenFamily chains the refresh tokens issued for a login, each one replacing the previous.
+// It points at the access session created by the last refresh.
+type TokenFamily struct {
+	Id        string
+	UserId    string
+	SessionId SessionID
+	CreatedAt time.Time
+	// ExpiresAt is when the last refresh token of the family expires
+	ExpiresAt time.Time
+	// RevokedAt is zero while the family can be refreshed
+	RevokedAt time.Time
+}
+
+// RefreshToken is a single-use token of a family, keyed by its hash like sessions
+type RefreshToken struct {
+	Id        string
+	FamilyId  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// UsedAt is zero until the token is exchanged
+	UsedAt time.Time
+}
+
+// Refreshed is the outcome of a refresh: a new access session and the refresh token
+// replacing the one exchanged
+type Refreshed struct {
+	Session          *Session
+	Token            Token
+	RefreshToken     Token
+	RefreshExpiresAt time.Time
+	// Replaced is the session of the exchanged refresh token, deleted by the refresh
+	Replaced SessionID
+}
+
+// ReuseError reports a refresh token exchanged twice. The whole family has been
+// revoked along with its current session, which it names.
+type ReuseError struct {
+	UserId    string
+	SessionId SessionID
+}
+
+func (e *ReuseError) Error() string {
+	return ErrRefreshTokenReused.Error()
+}
+
+func (e *ReuseError) Is(target error) bool {
+	return target == ErrRefreshTokenReused
+}
+
+// IssueRefreshToken starts a token family for a session returned by CreateSession,
+// and returns its first refresh token along with its expiry
+func (s *SessionService) IssueRefreshToken(session *Session) (Token, time.Time, error) {
+	if !s.refreshable() {
+		return "", time.Time{}, ErrRefreshTokensDisabled
+	}
+
+	now := time.Now()
+	token, refreshToken := s.newRefreshToken(newFamilyId(), now, now)
+	family := &TokenFamily{
+		Id:        refreshToken.FamilyId,
+		UserId:    session.UserId,
+		SessionId: session.Id,
+		CreatedAt: now,
+		ExpiresAt: refreshToken.ExpiresAt,
+	}
+	if err := s.store.CreateTokenFamily(family, refreshToken); err != nil {
+		return "", time.Time{}, fmt.Errorf("could not create token family: %w", err)
+	}
+	return token, refreshToken.ExpiresAt, nil
+}
+
+// Refresh exchanges a refresh token for a new session and a new refresh token, and
+// deletes the session it was issued with. Each refresh token can be exchanged once:
+// presenting it again revokes its whole family and returns a *ReuseError.
+func (s *SessionService) Refresh(token Token, metadata Metadata) (*Refreshed, error) {
+	if !s.refreshable() {
+		return nil, ErrRefreshTokensDisabled
+	}
+
+	// Only one exchange of a token can mark it used, concurrent ones are reuses
+	now := time.Now()
+	refreshToken, first, err := s.store.UseRefreshToken(string(token.SessionID()), now)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenNotFound):
+			return nil, ErrInvalidRefreshToken
+		default:
+			return nil, fmt.Errorf("could not use refresh token: %w", err)
+		}
+	}
+
+	family, err := s.store.GetTokenFamily(refreshToken.FamilyId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenNotFound):
+			return nil, ErrInvalidRefreshToken
+		default:
+			return nil, fmt.Errorf("could not query token family: %w", err)
+		}
+	}
+	if !family.RevokedAt.IsZero() {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// A used token came back, it leaked: log the family out, from the session it is at
+	// by now if the legitimate client is refreshing concurrently
+	if !first {
+		sessionId, err := s.revokeTokenFamily(family.Id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ReuseError{UserId: family.UserId, SessionId: sessionId}
+	}
+
+	if !now.Before(refreshToken.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, accessToken, err := s.CreateSession(family.UserId, metadata)
+	if err != nil {
+		return nil, err
+	}
+	newToken, next := s.newRefreshToken(family.Id, family.CreatedAt, now)
+	if err := s.store.RotateRefreshToken(family.Id, session.Id, next); err != nil {
+		// Revoked since it was read, the new session must not outlive the family
+		if invalidateErr := s.InvalidateSession(session.Id); invalidateErr != nil {
+			slog.Error("could not invalidate session of a revoked token family", "session_id", session.Id, "error", invalidateErr)
+		}
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("could not rotate refresh token: %w", err)
+	}
+
+	// The family moved to the new session, replacing the previous one does not revoke it
+	if err := s.store.Delete(family.SessionId); err != nil {
+		return nil, fmt.Errorf("could not delete replaced session: %w", err)
+	}
+	if err := s.revoke(Revocation{SessionId: family.SessionId}); err != nil {
+		return nil, err
+	}
+
+	return &Refreshed{
+		Session:          session,
+		Token:            accessToken,
+		RefreshToken:     newToken,
+		RefreshExpiresAt: next.ExpiresAt,
+		Replaced:         family.SessionId,
+	}, nil
+}
+
+// RevokeRefreshToken revokes the family of the refresh token along with its current
+// session, e.g. on logout once the access session expired. It returns the id of that
+// session, or ErrInvalidRefreshToken for an unknown token.
+func (s *SessionService) RevokeRefreshToken(token Token) (SessionID, error) {
+	if !s.refreshable() {
+		return "", ErrRefreshTokensDisabled
+	}
+
+	refreshToken, err := s.store.GetRefreshToken(string(token.SessionID()))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenNotFound):
+			return "", ErrInvalidRefreshToken
+		default:
+			return "", fmt.Errorf("could not query refresh token: %w", err)
+		}
+	}
+
+	sessionId, err := s.revokeTokenFamily(refreshToken.FamilyId)
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return "", ErrInvalidRefreshToken
+	}
+	return sessionId, err
+}
+
+// revokeTokenFamily revokes the family along with its current session, and returns
+// the id of that session
+func (s *SessionService) revokeTokenFamily(familyId string) (SessionID, error) {
+	sessionId, err := s.store.RevokeTokenFamily(familyId, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("could not revoke token family: %w", err)
+	}
+	// The signed token of the session stays valid without a revocation
+	if err := s.revoke(Revocation{SessionId: sessionId}); err != nil {
+		return "", err
+	}
+	return sessionId, nil
+}
+
+// newRefreshToken generates the next token of a family created at familyCreatedAt.
+// Refresh tokens never outlive the absolute lifetime of the login.
+func (s *SessionService) newRefreshToken(familyId string, familyCreatedAt, now time.Time) (Token, *RefreshToken) {
+	token := generateToken()
+	expiresAt := now.Add(s.config.RefreshTokenLifetime)
+	if s.config.AbsoluteLifetime > 0 {
+		if limit := familyCreatedAt.Add(s.config.AbsoluteLifetime); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	return token, &RefreshToken{
+		Id:        string(token.SessionID()),
+		FamilyId:  familyId,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func newFamilyId() string {
+	return string(generateToken().SessionID())
+}
+
+func (s *SessionService) refreshable() bool {
+	return s.config.RefreshTokenLifetime > 0
+}
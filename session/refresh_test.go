@@ -0,0 +1,226 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupRefreshService() (*SessionService, *MemoryStore) {
+	store := NewMemoryStore()
+	config := DefaultConfig()
+	config.IdleTimeout = 15 * time.Minute
+	config.RefreshTokenLifetime = 24 * time.Hour
+	return New(store, config), store
+}
+
+func login(t *testing.T, s *SessionService, userId string) (*Session, Token) {
+	t.Helper()
+	session, _, err := s.CreateSession(userId, Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	refreshToken, _, err := s.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+	return session, refreshToken
+}
+
+func TestRefresh_Rotation(t *testing.T) {
+	s, store := setupRefreshService()
+	first, refreshToken := login(t, s, "user123")
+
+	refreshed, err := s.Refresh(refreshToken, Metadata{UserAgent: "test"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if refreshed.Replaced != first.Id || refreshed.Session.UserId != "user123" || refreshed.Session.UserAgent != "test" {
+		t.Errorf("unexpected refresh %+v", refreshed)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == refreshToken {
+		t.Errorf("expected a new refresh token, got %q", refreshed.RefreshToken)
+	}
+	if _, err := store.Get(first.Id); err != ErrSessionNotFound {
+		t.Errorf("expected the replaced session to be deleted, got %v", err)
+	}
+	if _, err := s.ValidateSession(refreshed.Token); err != nil {
+		t.Errorf("expected the new session to be valid, got %v", err)
+	}
+
+	// The new refresh token rotates again
+	if _, err := s.Refresh(refreshed.RefreshToken, Metadata{}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	s, store := setupRefreshService()
+	_, refreshToken := login(t, s, "user123")
+	_, otherToken := login(t, s, "user123")
+
+	refreshed, err := s.Refresh(refreshToken, Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	_, err = s.Refresh(refreshToken, Metadata{})
+	var reused *ReuseError
+	if !errors.As(err, &reused) || !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected a ReuseError, got %v", err)
+	}
+	if reused.SessionId != refreshed.Session.Id || reused.UserId != "user123" {
+		t.Errorf("expected the error to name the current session, got %+v", reused)
+	}
+	if _, err := store.Get(refreshed.Session.Id); err != ErrSessionNotFound {
+		t.Errorf("expected the family's session to be deleted, got %v", err)
+	}
+	if _, err := s.Refresh(refreshed.RefreshToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected the latest refresh token to be revoked, got %v", err)
+	}
+
+	// Other logins of the user are not affected
+	if _, err := s.Refresh(otherToken, Metadata{}); err != nil {
+		t.Errorf("expected no error for another family, got %v", err)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	s, store := setupRefreshService()
+	_, refreshToken := login(t, s, "user123")
+	refreshed, err := s.Refresh(refreshToken, Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Any token of the family revokes it, from the session it is at
+	sessionId, err := s.RevokeRefreshToken(refreshToken)
+	if err != nil || sessionId != refreshed.Session.Id {
+		t.Fatalf("RevokeRefreshToken: got (%q, %v), want (%q, nil)", sessionId, err, refreshed.Session.Id)
+	}
+	if _, err := store.Get(refreshed.Session.Id); err != ErrSessionNotFound {
+		t.Errorf("expected the family's session to be deleted, got %v", err)
+	}
+	if _, err := s.Refresh(refreshed.RefreshToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+
+	if _, err := s.RevokeRefreshToken("unknown"); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+// revokingStore revokes the family of the token being refreshed right before it rotates
+type revokingStore struct {
+	*MemoryStore
+}
+
+func (s revokingStore) RotateRefreshToken(familyId string, sessionId SessionID, token *RefreshToken) error {
+	s.RevokeTokenFamily(familyId, time.Now())
+	return s.MemoryStore.RotateRefreshToken(familyId, sessionId, token)
+}
+
+func TestRefresh_RevokedDuringRotation(t *testing.T) {
+	store := NewMemoryStore()
+	config := DefaultConfig()
+	config.RefreshTokenLifetime = 24 * time.Hour
+	s := New(revokingStore{store}, config)
+	_, refreshToken := login(t, s, "user123")
+
+	if _, err := s.Refresh(refreshToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+	if sessions, _ := store.ListByUser("user123", time.Now()); len(sessions) != 0 {
+		t.Errorf("expected no session to outlive the revoked family, got %d", len(sessions))
+	}
+}
+
+func TestRefresh_Revoked(t *testing.T) {
+	s, _ := setupRefreshService()
+
+	// Logging out ends the family
+	session, refreshToken := login(t, s, "user123")
+	if err := s.InvalidateSession(session.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := s.Refresh(refreshToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken after logout, got %v", err)
+	}
+
+	// So do revocations made outside of the service, e.g. a password change
+	current, currentToken := login(t, s, "user123")
+	_, otherToken := login(t, s, "user123")
+	s.SessionsRevoked("user123", current.Id)
+	if _, err := s.Refresh(otherToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken after revocation, got %v", err)
+	}
+	if _, err := s.Refresh(currentToken, Metadata{}); err != nil {
+		t.Errorf("expected the current session to keep refreshing, got %v", err)
+	}
+
+	if _, err := s.Refresh("unknown", Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefresh_ExpiredSession(t *testing.T) {
+	s, store := setupRefreshService()
+	session, token, _ := s.CreateSession("user123", Metadata{})
+	refreshToken, _, err := s.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+
+	// Access sessions are not extended, they are replaced once expired
+	past := time.Now().Add(-time.Minute)
+	store.Touch(session.Id, past, past)
+	if _, err := s.ValidateSession(token); err != ErrExpiredSession {
+		t.Fatalf("expected ErrExpiredSession, got %v", err)
+	}
+	if _, err := s.Refresh(refreshToken, Metadata{}); err != nil {
+		t.Errorf("expected the refresh token to outlive its session, got %v", err)
+	}
+}
+
+func TestRefresh_Expired(t *testing.T) {
+	s, store := setupRefreshService()
+	_, refreshToken := login(t, s, "user123")
+
+	id := string(refreshToken.SessionID())
+	token := store.refresh[id]
+	token.ExpiresAt = time.Now().Add(-time.Second)
+	store.refresh[id] = token
+
+	if _, err := s.Refresh(refreshToken, Metadata{}); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefresh_Disabled(t *testing.T) {
+	s, _ := setupService()
+	session, _, _ := s.CreateSession("user123", Metadata{})
+
+	if _, _, err := s.IssueRefreshToken(session); err != ErrRefreshTokensDisabled {
+		t.Errorf("expected ErrRefreshTokensDisabled, got %v", err)
+	}
+	if _, err := s.Refresh("token", Metadata{}); err != ErrRefreshTokensDisabled {
+		t.Errorf("expected ErrRefreshTokensDisabled, got %v", err)
+	}
+}
+
+func TestRefresh_AccessSessionsAreNotExtended(t *testing.T) {
+	s, store := setupRefreshService()
+	session, token, _ := s.CreateSession("user123", Metadata{})
+
+	// Close to expiry, a sliding session would be extended
+	soon := time.Now().Add(time.Minute)
+	store.Touch(session.Id, time.Now(), soon)
+
+	validated, err := s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !validated.ExpiresAt.Equal(soon) {
+		t.Errorf("expected the expiry to stay at %v, got %v", soon, validated.ExpiresAt)
+	}
+}
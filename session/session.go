@@ -0,0 +1,368 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/utils"
+)
+
+// Default session lifetimes
+const (
+	DefaultIdleTimeout      = 24 * time.Hour
+	DefaultRefreshThreshold = DefaultIdleTimeout / 2
+	DefaultTouchInterval    = time.Minute
+)
+
+const COOKIE_NAME = "auth_session"
+
+// Token is the secret handed to the client. It is never stored, only its hash is.
+type Token string
+
+// SessionID identifies a session in the store, it is the SHA-256 hash of the session token
+type SessionID string
+
+// SessionID derives the id of the session the token belongs to
+func (t Token) SessionID() SessionID {
+	h := sha256.New()
+	_, err := h.Write([]byte(t))
+	if err != nil {
+		return ""
+	}
+	return SessionID(hex.EncodeToString(h.Sum(nil)))
+}
+
+// Error constants for session handling
+var (
+	ErrExpiredSession = errors.New("expired session")
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+// Session struct to represent session data
+type Session struct {
+	UserId     string    `json:"user_id"`      // ID of the user who owns the session
+	Id         SessionID `json:"id"`           // Unique ID of the session
+	ExpiresAt  time.Time `json:"expires_at"`   // Timestamp when the session expires
+	CreatedAt  time.Time `json:"created_at"`   // Timestamp when the session was created
+	LastSeenAt time.Time `json:"last_seen_at"` // Timestamp of the last activity on the session
+	IP         string    `json:"ip"`           // IP address of the client that created the session
+	UserAgent  string    `json:"user_agent"`   // User agent of the client that created the session
+}
+
+// Metadata describes the client creating a session
+type Metadata struct {
+	IP        string
+	UserAgent string
+}
+
+// Config controls how long sessions live
+type Config struct {
+	// IdleTimeout expires a session after this long without activity, 0 disables it
+	IdleTimeout time.Duration
+	// AbsoluteLifetime expires a session this long after its creation regardless of activity, 0 disables it
+	AbsoluteLifetime time.Duration
+	// RefreshThreshold is the remaining lifetime under which a validation pushes the expiry back.
+	// Inactivity is tracked at this granularity, keep it well below IdleTimeout.
+	RefreshThreshold time.Duration
+	// TouchInterval is the minimum time between two activity writes of a session, so that a
+	// hot session does not write on every validation. 0 records every validation.
+	// Inactivity is tracked at this granularity too, keep it well below IdleTimeout.
+	TouchInterval time.Duration
+	// Keys switches to signed tokens when set: they embed the user, the session and
+	// the expiry, and are validated without reading the session. Signed tokens are never
+	// extended, they expire at the end of the lifetime computed when they are issued.
+	Keys Keys
+	// RefreshTokenLifetime enables refresh tokens when set: each one can be exchanged once
+	// for a new session and a new refresh token, until it expires. Sessions are then
+	// short-lived access sessions, never extended by a validation.
+	RefreshTokenLifetime time.Duration
+}
+
+// DefaultConfig returns 24h sliding sessions without an absolute lifetime
+func DefaultConfig() Config {
+	return Config{
+		IdleTimeout:      DefaultIdleTimeout,
+		RefreshThreshold: DefaultRefreshThreshold,
+	}
+}
+
+// RevocationListener is told about sessions revoked outside of the session service,
+// e.g. along with a password change, so it can drop what it knows about them
+type RevocationListener interface {
+	SessionsRevoked(userId string, exceptId SessionID)
+}
+
+type ISessionService interface {
+	CreateSession(userId string, metadata Metadata) (*Session, Token, error)
+	ValidateSession(token Token) (*Session, error)
+	InvalidateSession(sessionId SessionID) error
+	ListSessions(userId string) ([]*Session, error)
+	RevokeSession(userId string, sessionId SessionID) error
+	RevokeOtherSessions(userId string, currentSessionId SessionID) (int, error)
+	IssueRefreshToken(session *Session) (Token, time.Time, error)
+	Refresh(refreshToken Token, metadata Metadata) (*Refreshed, error)
+	RevokeRefreshToken(refreshToken Token) (SessionID, error)
+}
+
+type SessionService struct {
+	store  SessionStore
+	config Config
+}
+
+func New(store SessionStore, config Config) *SessionService {
+	// A session must expire eventually
+	if config.IdleTimeout <= 0 && config.AbsoluteLifetime <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.RefreshThreshold <= 0 {
+		config.RefreshThreshold = config.IdleTimeout / 2
+	}
+	return &SessionService{
+		store:  store,
+		config: config,
+	}
+}
+
+// ValidateSession checks if a session is valid and refreshes it if it is close to expiring.
+// Signed tokens are checked against their signature and the revocations instead,
+// the session returned then only carries what the token holds.
+func (s *SessionService) ValidateSession(token Token) (*Session, error) {
+	if s.signed() {
+		return s.validateSigned(token)
+	}
+
+	// Generate a session ID from the token using SHA-256
+	sessionId := token.SessionID()
+
+	// Look up the session in the store
+	session, err := s.store.Get(sessionId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSessionNotFound):
+			return nil, ErrInvalidSession
+		default:
+			return nil, fmt.Errorf("could not query session: %w", err)
+		}
+	}
+
+	// Check if the session is expired, either idle for too long or past its absolute lifetime
+	now := time.Now()
+	if s.expired(session, now) {
+		// Only the session is deleted, its refresh tokens can still replace it
+		err := s.store.Delete(session.Id)
+		if err != nil {
+			slog.Error("could not invalidate expired session", "error", err)
+		}
+		return nil, ErrExpiredSession
+	}
+
+	// Refresh the session once it gets close to expiration, access sessions are replaced instead
+	refreshed := false
+	if !s.refreshable() && session.ExpiresAt.Sub(now) < s.config.RefreshThreshold {
+		if expiresAt := s.expiresAt(session.CreatedAt, now); expiresAt.After(session.ExpiresAt) {
+			session.ExpiresAt = expiresAt
+			refreshed = true
+		}
+	}
+
+	// Record the activity, at most once per touch interval unless the expiry moved
+	if refreshed || now.Sub(session.LastSeenAt) >= s.config.TouchInterval {
+		session.LastSeenAt = now
+		err = s.store.Touch(session.Id, session.LastSeenAt, session.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("could not refresh session: %w", err)
+		}
+	}
+
+	return session, nil
+}
+
+func (s *SessionService) validateSigned(token Token) (*Session, error) {
+	now := time.Now()
+	claims, err := VerifyToken(token, s.config.Keys, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply a tightened absolute lifetime to the tokens issued before
+	session := claims.session()
+	if s.config.AbsoluteLifetime > 0 && now.After(session.CreatedAt.Add(s.config.AbsoluteLifetime)) {
+		return nil, ErrExpiredSession
+	}
+
+	revoked, err := s.store.Revoked(session.Id, session.UserId, session.CreatedAt, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not query revocations: %w", err)
+	}
+	if revoked {
+		return nil, ErrInvalidSession
+	}
+
+	return session, nil
+}
+
+// CreateSession generates a new session, saves it to the store and returns it along
+// with the token to hand to the client
+func (s *SessionService) CreateSession(userId string, metadata Metadata) (*Session, Token, error) {
+	// Derive the session ID from a random token
+	token := generateToken()
+	sessionId := token.SessionID()
+
+	// Create a new session with an expiration time
+	now := time.Now()
+	session := &Session{
+		UserId:     userId,
+		Id:         sessionId,
+		ExpiresAt:  s.expiresAt(now, now),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IP:         metadata.IP,
+		UserAgent:  metadata.UserAgent,
+	}
+
+	// Signed tokens carry the session instead, the random token is only used for its id
+	if s.signed() {
+		kid, key := s.config.Keys.SigningKey()
+		var err error
+		token, err = SignToken(Claims{
+			Subject:   userId,
+			SessionId: sessionId,
+			IssuedAt:  NumericDate{now},
+			ExpiresAt: NumericDate{session.ExpiresAt},
+		}, kid, key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Save the session to the store
+	err := s.store.Create(session)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create session: %w", err)
+	}
+
+	return session, token, nil
+}
+
+func generateToken() Token {
+	return Token(utils.GenerateRandomString())
+}
+
+// InvalidateSession removes a session from the store by ID, and revokes its signed token
+// and its refresh tokens
+func (s *SessionService) InvalidateSession(sessionId SessionID) error {
+	err := s.store.Delete(sessionId)
+	if err != nil {
+		return fmt.Errorf("could not invalidate session: %w", err)
+	}
+	return s.revoke(Revocation{SessionId: sessionId})
+}
+
+// SessionsRevoked revokes the signed and refresh tokens of sessions deleted outside of
+// the service, e.g. along with a password change. Opaque tokens need nothing more than the deletion.
+func (s *SessionService) SessionsRevoked(userId string, exceptId SessionID) {
+	if err := s.revoke(Revocation{UserId: userId, ExceptId: exceptId}); err != nil {
+		slog.Error("could not revoke tokens", "user_id", userId, "error", err)
+	}
+}
+
+// revoke revokes the token families of the sessions covered, and records the
+// revocation until every signed token it covers has expired
+func (s *SessionService) revoke(revocation Revocation) error {
+	now := time.Now()
+	revocation.RevokedAt = now
+	if s.refreshable() {
+		if _, err := s.store.RevokeTokenFamilies(revocation); err != nil {
+			return fmt.Errorf("could not revoke refresh tokens: %w", err)
+		}
+	}
+
+	if !s.signed() {
+		return nil
+	}
+
+	revocation.ExpiresAt = s.expiresAt(now, now)
+	if err := s.store.Revoke(revocation); err != nil {
+		return fmt.Errorf("could not revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionService) signed() bool {
+	return s.config.Keys != nil
+}
+
+// expiresAt computes the expiry of a session active at the given time
+func (s *SessionService) expiresAt(createdAt, now time.Time) time.Time {
+	var expiresAt time.Time
+	if s.config.IdleTimeout > 0 {
+		expiresAt = now.Add(s.config.IdleTimeout)
+	}
+	if s.config.AbsoluteLifetime > 0 {
+		limit := createdAt.Add(s.config.AbsoluteLifetime)
+		if expiresAt.IsZero() || expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	return expiresAt
+}
+
+// expired checks the session against its stored expiry and the current limits,
+// so that tightening the configuration applies to existing sessions too
+func (s *SessionService) expired(session *Session, now time.Time) bool {
+	if now.After(session.ExpiresAt) {
+		return true
+	}
+	if s.config.IdleTimeout > 0 && now.After(session.LastSeenAt.Add(s.config.IdleTimeout)) {
+		return true
+	}
+	if s.config.AbsoluteLifetime > 0 && now.After(session.CreatedAt.Add(s.config.AbsoluteLifetime)) {
+		return true
+	}
+	return false
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *SessionService) ListSessions(userId string) ([]*Session, error) {
+	sessions, err := s.store.ListByUser(userId, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession invalidates one of the user's sessions by its public id.
+// Sessions belonging to someone else are reported as ErrInvalidSession.
+func (s *SessionService) RevokeSession(userId string, sessionId SessionID) error {
+	session, err := s.store.Get(sessionId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSessionNotFound):
+			return ErrInvalidSession
+		default:
+			return fmt.Errorf("could not query session: %w", err)
+		}
+	}
+	if session.UserId != userId {
+		return ErrInvalidSession
+	}
+
+	return s.InvalidateSession(session.Id)
+}
+
+// RevokeOtherSessions invalidates every session of the user except the current one,
+// and returns how many were revoked
+func (s *SessionService) RevokeOtherSessions(userId string, currentSessionId SessionID) (int, error) {
+	revoked, err := s.store.DeleteByUser(userId, currentSessionId)
+	if err != nil {
+		return 0, fmt.Errorf("could not revoke sessions: %w", err)
+	}
+	if err := s.revoke(Revocation{UserId: userId, ExceptId: currentSessionId}); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
@@ -0,0 +1,304 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func setupService() (*SessionService, *MemoryStore) {
+	store := NewMemoryStore()
+	return New(store, DefaultConfig()), store
+}
+
+func TestCreateSession(t *testing.T) {
+	s, _ := setupService()
+
+	userID := "user123"
+	session, token, err := s.CreateSession(userID, Metadata{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if session.UserId != userID {
+		t.Errorf("expected user ID %s, got %s", userID, session.UserId)
+	}
+
+	if session.Id != token.SessionID() {
+		t.Errorf("expected the session id to be the hash of the token, got %s", session.Id)
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		t.Errorf("expected expiration time to be in the future, got %v", session.ExpiresAt)
+	}
+}
+
+func TestValidateSession_Valid(t *testing.T) {
+	s, _ := setupService()
+
+	userID := "user123"
+	session, token, err := s.CreateSession(userID, Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	validatedSession, err := s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if validatedSession.Id != session.Id {
+		t.Errorf("expected session ID %s, got %s", session.Id, validatedSession.Id)
+	}
+}
+
+func TestValidateSession_Expired(t *testing.T) {
+	s, store := setupService()
+
+	// Create a session that is already expired
+	userID := "user123"
+	token := generateToken()
+	session := &Session{
+		UserId:    userID,
+		Id:        token.SessionID(),
+		ExpiresAt: time.Now().Add(-time.Hour), // Set expiration time to 1 hour ago
+	}
+
+	err := store.Create(session)
+	if err != nil {
+		t.Fatalf("failed to insert expired session: %v", err)
+	}
+
+	_, err = s.ValidateSession(token)
+	if err != ErrExpiredSession {
+		t.Errorf("expected ErrExpiredSession, got %v", err)
+	}
+}
+
+func TestInvalidateSession(t *testing.T) {
+	s, store := setupService()
+
+	userID := "user123"
+	session, token, err := s.CreateSession(userID, Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	err = s.InvalidateSession(session.Id)
+	if err != nil {
+		t.Fatalf("expected no error when invalidating session, got %v", err)
+	}
+
+	// Check if the session still exists
+	if _, err := store.Get(session.Id); err != ErrSessionNotFound {
+		t.Errorf("expected session to be deleted, got %v", err)
+	}
+	if _, err := s.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+}
+
+func TestValidateSession_IdleTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store, Config{IdleTimeout: 30 * time.Minute, RefreshThreshold: time.Minute})
+
+	// Stored expiry is still ahead, but the session has been idle for longer than allowed
+	token := generateToken()
+	now := time.Now()
+	session := &Session{
+		UserId:     "user123",
+		Id:         token.SessionID(),
+		ExpiresAt:  now.Add(time.Hour),
+		CreatedAt:  now.Add(-time.Hour),
+		LastSeenAt: now.Add(-31 * time.Minute),
+	}
+	if err := store.Create(session); err != nil {
+		t.Fatalf("failed to insert session: %v", err)
+	}
+
+	_, err := s.ValidateSession(token)
+	if err != ErrExpiredSession {
+		t.Errorf("expected ErrExpiredSession, got %v", err)
+	}
+}
+
+func TestValidateSession_AbsoluteLifetime(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store, Config{IdleTimeout: 30 * time.Minute, AbsoluteLifetime: 12 * time.Hour, RefreshThreshold: time.Minute})
+
+	// Active a minute ago, but created more than 12 hours ago
+	token := generateToken()
+	now := time.Now()
+	session := &Session{
+		UserId:     "user123",
+		Id:         token.SessionID(),
+		ExpiresAt:  now.Add(29 * time.Minute),
+		CreatedAt:  now.Add(-13 * time.Hour),
+		LastSeenAt: now.Add(-time.Minute),
+	}
+	if err := store.Create(session); err != nil {
+		t.Fatalf("failed to insert session: %v", err)
+	}
+
+	_, err := s.ValidateSession(token)
+	if err != ErrExpiredSession {
+		t.Errorf("expected ErrExpiredSession, got %v", err)
+	}
+}
+
+func TestValidateSession_Refresh(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store, Config{IdleTimeout: 30 * time.Minute, AbsoluteLifetime: 12 * time.Hour, RefreshThreshold: 10 * time.Minute})
+
+	session, token, err := s.CreateSession("user123", Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// Plenty of lifetime left, the expiry is not extended
+	validated, err := s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !validated.ExpiresAt.Equal(session.ExpiresAt) {
+		t.Errorf("expected expiry to stay %v, got %v", session.ExpiresAt, validated.ExpiresAt)
+	}
+
+	// Under the refresh threshold, the expiry slides but never past the absolute lifetime
+	now := time.Now()
+	session.CreatedAt = now.Add(-12*time.Hour + 20*time.Minute)
+	session.LastSeenAt = now.Add(-25 * time.Minute)
+	session.ExpiresAt = now.Add(5 * time.Minute)
+	if err := store.Create(session); err != nil {
+		t.Fatalf("failed to update session: %v", err)
+	}
+
+	validated, err = s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	limit := session.CreatedAt.Add(12 * time.Hour)
+	if !validated.ExpiresAt.Equal(limit) {
+		t.Errorf("expected expiry capped at %v, got %v", limit, validated.ExpiresAt)
+	}
+	stored, _ := store.Get(session.Id)
+	if !stored.ExpiresAt.Equal(limit) || !stored.LastSeenAt.After(session.LastSeenAt) {
+		t.Errorf("expected refreshed session to be stored, got %+v", stored)
+	}
+}
+
+func TestSessionMetadata(t *testing.T) {
+	s, store := setupService()
+
+	session, token, err := s.CreateSession("user123", Metadata{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if session.IP != "203.0.113.7" || session.UserAgent != "Mozilla/5.0" {
+		t.Errorf("expected client metadata on the session, got %+v", session)
+	}
+	if session.CreatedAt.IsZero() || !session.LastSeenAt.Equal(session.CreatedAt) {
+		t.Errorf("expected created and last seen timestamps to be set, got %+v", session)
+	}
+
+	time.Sleep(time.Millisecond)
+	validated, err := s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !validated.LastSeenAt.After(session.LastSeenAt) {
+		t.Errorf("expected last seen to move forward, got %v", validated.LastSeenAt)
+	}
+
+	stored, _ := store.Get(session.Id)
+	if !stored.LastSeenAt.Equal(validated.LastSeenAt) || stored.IP != "203.0.113.7" {
+		t.Errorf("expected activity to be stored, got %+v", stored)
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	s, _ := setupService()
+
+	var ids []SessionID
+	for i := 0; i < 3; i++ {
+		session, _, err := s.CreateSession("user123", Metadata{})
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		ids = append(ids, session.Id)
+	}
+	other, _, err := s.CreateSession("someoneElse", Metadata{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	sessions, err := s.ListSessions("user123")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sessions))
+	}
+
+	// A user cannot revoke somebody else's session
+	if err := s.RevokeSession("user123", other.Id); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+
+	if err := s.RevokeSession("user123", ids[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.RevokeSession("user123", ids[0]); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession for an already revoked session, got %v", err)
+	}
+
+	revoked, err := s.RevokeOtherSessions("user123", ids[1])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("expected 1 revoked session, got %d", revoked)
+	}
+
+	sessions, _ = s.ListSessions("user123")
+	if len(sessions) != 1 || sessions[0].Id != ids[1] {
+		t.Errorf("expected only the current session to remain, got %+v", sessions)
+	}
+	if sessions, _ := s.ListSessions("someoneElse"); len(sessions) != 1 {
+		t.Errorf("expected other users' sessions to be kept, got %d", len(sessions))
+	}
+}
+
+func TestValidateSession_TouchInterval(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store, Config{IdleTimeout: 30 * time.Minute, RefreshThreshold: time.Minute, TouchInterval: time.Minute})
+
+	now := time.Now()
+	session := &Session{
+		UserId:     "user123",
+		Id:         Token("token").SessionID(),
+		ExpiresAt:  now.Add(20 * time.Minute),
+		CreatedAt:  now.Add(-time.Hour),
+		LastSeenAt: now.Add(-30 * time.Second),
+	}
+	store.Create(session)
+
+	// Seen less than a touch interval ago, nothing is written
+	if _, err := s.ValidateSession("token"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stored, _ := store.Get(session.Id)
+	if !stored.LastSeenAt.Equal(session.LastSeenAt) {
+		t.Errorf("expected the activity write to be coalesced, got %v", stored.LastSeenAt)
+	}
+
+	// Seen more than a touch interval ago, the activity is recorded
+	session.LastSeenAt = now.Add(-2 * time.Minute)
+	store.Create(session)
+	if _, err := s.ValidateSession("token"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stored, _ = store.Get(session.Id)
+	if !stored.LastSeenAt.After(session.LastSeenAt) {
+		t.Errorf("expected the activity to be recorded, got %v", stored.LastSeenAt)
+	}
+}
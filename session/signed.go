@@ -0,0 +1,127 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// PublicKeys finds the key verifying a token by the key id in its header
+type PublicKeys interface {
+	PublicKey(kid string) (ed25519.PublicKey, bool)
+}
+
+// Keys signs new tokens with the active key, and verifies tokens signed by any known key
+type Keys interface {
+	PublicKeys
+	SigningKey() (kid string, key ed25519.PrivateKey)
+}
+
+// Claims are the contents of a signed session token, a JWT signed with Ed25519 (EdDSA)
+type Claims struct {
+	Subject   string      `json:"sub"` // ID of the user who owns the session
+	SessionId SessionID   `json:"sid"` // ID of the session
+	IssuedAt  NumericDate `json:"iat"` // Creation of the session
+	ExpiresAt NumericDate `json:"exp"` // Expiration of the token, signed tokens are never extended
+}
+
+// NumericDate is a JWT timestamp, in seconds since the epoch with millisecond precision
+type NumericDate struct {
+	time.Time
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(d.UnixMilli()) / 1000)
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return err
+	}
+	d.Time = time.UnixMilli(int64(math.Floor(seconds * 1000)))
+	return nil
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Only EdDSA is accepted, a token cannot pick its own algorithm
+const signingAlgorithm = "EdDSA"
+
+var encoding = base64.RawURLEncoding
+
+// SignToken issues a token carrying the claims, signed by the key identified by kid
+func SignToken(claims Claims, kid string, key ed25519.PrivateKey) (Token, error) {
+	header, err := json.Marshal(tokenHeader{Algorithm: signingAlgorithm, Type: "JWT", KeyId: kid})
+	if err != nil {
+		return "", fmt.Errorf("could not encode token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("could not encode token claims: %w", err)
+	}
+
+	signingInput := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	signature := ed25519.Sign(key, []byte(signingInput))
+	return Token(signingInput + "." + encoding.EncodeToString(signature)), nil
+}
+
+// VerifyToken checks the signature and expiry of a signed token and returns its claims.
+// It does not know about revocations, services verifying tokens on their own accept
+// a revoked token until it expires.
+func VerifyToken(token Token, keys PublicKeys, now time.Time) (*Claims, error) {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSession
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != signingAlgorithm {
+		return nil, ErrInvalidSession
+	}
+	key, ok := keys.PublicKey(header.KeyId)
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidSession
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.SessionId == "" {
+		return nil, ErrInvalidSession
+	}
+	if now.After(claims.ExpiresAt.Time) {
+		return nil, ErrExpiredSession
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, value any) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// session rebuilds what the token tells about its session
+func (c *Claims) session() *Session {
+	return &Session{
+		UserId:     c.Subject,
+		Id:         c.SessionId,
+		ExpiresAt:  c.ExpiresAt.Time,
+		CreatedAt:  c.IssuedAt.Time,
+		LastSeenAt: c.IssuedAt.Time,
+	}
+}
@@ -0,0 +1,178 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys is a single key Keys
+type testKeys struct {
+	kid string
+	key ed25519.PrivateKey
+}
+
+func (k testKeys) SigningKey() (string, ed25519.PrivateKey) {
+	return k.kid, k.key
+}
+
+func (k testKeys) PublicKey(kid string) (ed25519.PublicKey, bool) {
+	if kid != k.kid {
+		return nil, false
+	}
+	return k.key.Public().(ed25519.PublicKey), true
+}
+
+func newKeys(t *testing.T, kid string) testKeys {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return testKeys{kid: kid, key: key}
+}
+
+func TestSignAndVerifyToken(t *testing.T) {
+	keys := newKeys(t, "current")
+	now := time.Now()
+	claims := Claims{
+		Subject:   "user123",
+		SessionId: "sessionId",
+		IssuedAt:  NumericDate{now},
+		ExpiresAt: NumericDate{now.Add(time.Hour)},
+	}
+
+	token, err := SignToken(claims, keys.kid, keys.key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	verified, err := VerifyToken(token, keys, now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if verified.Subject != "user123" || verified.SessionId != "sessionId" {
+		t.Errorf("unexpected claims: %+v", verified)
+	}
+	if !verified.IssuedAt.Equal(now.Truncate(time.Millisecond)) || !verified.ExpiresAt.Equal(now.Add(time.Hour).Truncate(time.Millisecond)) {
+		t.Errorf("expected timestamps with millisecond precision, got %+v", verified)
+	}
+
+	if _, err := VerifyToken(token, keys, now.Add(2*time.Hour)); err != ErrExpiredSession {
+		t.Errorf("expired token: expected ErrExpiredSession, got %v", err)
+	}
+	if _, err := VerifyToken(token, newKeys(t, "current"), now); err != ErrInvalidSession {
+		t.Errorf("other key with the same id: expected ErrInvalidSession, got %v", err)
+	}
+	if _, err := VerifyToken(token, testKeys{kid: "other", key: keys.key}, now); err != ErrInvalidSession {
+		t.Errorf("unknown key id: expected ErrInvalidSession, got %v", err)
+	}
+}
+
+func TestVerifyToken_Tampered(t *testing.T) {
+	keys := newKeys(t, "current")
+	now := time.Now()
+	token, _ := SignToken(Claims{Subject: "user123", SessionId: "sessionId", IssuedAt: NumericDate{now}, ExpiresAt: NumericDate{now.Add(time.Hour)}}, keys.kid, keys.key)
+	parts := strings.Split(string(token), ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","sid":"sessionId","iat":0,"exp":99999999999}`))
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"current"}`))
+
+	tests := map[string]Token{
+		"opaque":           "ABCDEFGHIJKLMNOPQRSTUVWX",
+		"forged claims":    Token(parts[0] + "." + forged + "." + parts[2]),
+		"alg none":         Token(unsigned + "." + parts[1] + "."),
+		"missing part":     Token(parts[0] + "." + parts[1]),
+		"garbage segments": "a.b.c",
+	}
+	for name, token := range tests {
+		if _, err := VerifyToken(token, keys, now); err != ErrInvalidSession {
+			t.Errorf("%s: expected ErrInvalidSession, got %v", name, err)
+		}
+	}
+}
+
+func setupSignedService(t *testing.T) (*SessionService, *MemoryStore) {
+	store := NewMemoryStore()
+	config := DefaultConfig()
+	config.Keys = newKeys(t, "current")
+	return New(store, config), store
+}
+
+func TestSignedSession(t *testing.T) {
+	s, store := setupSignedService(t)
+
+	created, token, err := s.CreateSession("user123", Metadata{IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if token.SessionID() == created.Id {
+		t.Errorf("expected a signed token, got an opaque one")
+	}
+	if _, err := store.Get(created.Id); err != nil {
+		t.Errorf("expected the session to be stored for listing, got %v", err)
+	}
+
+	// Validation does not need the stored session
+	store.Delete(created.Id)
+	validated, err := s.ValidateSession(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if validated.Id != created.Id || validated.UserId != "user123" || !validated.ExpiresAt.Equal(created.ExpiresAt.Truncate(time.Millisecond)) {
+		t.Errorf("unexpected session: %+v", validated)
+	}
+
+	if err := s.InvalidateSession(created.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := s.ValidateSession(token); err != ErrInvalidSession {
+		t.Errorf("invalidated token: expected ErrInvalidSession, got %v", err)
+	}
+}
+
+func TestSignedSession_UserRevocations(t *testing.T) {
+	s, _ := setupSignedService(t)
+
+	current, currentToken, _ := s.CreateSession("user123", Metadata{})
+	_, otherToken, _ := s.CreateSession("user123", Metadata{})
+	_, someoneElseToken, _ := s.CreateSession("user456", Metadata{})
+
+	if _, err := s.RevokeOtherSessions("user123", current.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := s.ValidateSession(otherToken); err != ErrInvalidSession {
+		t.Errorf("other session: expected ErrInvalidSession, got %v", err)
+	}
+	if _, err := s.ValidateSession(currentToken); err != nil {
+		t.Errorf("current session: expected no error, got %v", err)
+	}
+	if _, err := s.ValidateSession(someoneElseToken); err != nil {
+		t.Errorf("someone else's session: expected no error, got %v", err)
+	}
+
+	// Revoked along with an account lock, tokens issued afterwards are valid
+	s.SessionsRevoked("user123", "")
+	if _, err := s.ValidateSession(currentToken); err != ErrInvalidSession {
+		t.Errorf("current session after lock: expected ErrInvalidSession, got %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	_, newToken, _ := s.CreateSession("user123", Metadata{})
+	if _, err := s.ValidateSession(newToken); err != nil {
+		t.Errorf("new session: expected no error, got %v", err)
+	}
+}
+
+func TestSignedSession_AbsoluteLifetime(t *testing.T) {
+	keys := newKeys(t, "current")
+	s := New(NewMemoryStore(), Config{IdleTimeout: time.Hour, AbsoluteLifetime: time.Hour, Keys: keys})
+
+	// Issued before the lifetime was tightened
+	now := time.Now()
+	token, _ := SignToken(Claims{Subject: "user123", SessionId: "sessionId", IssuedAt: NumericDate{now.Add(-2 * time.Hour)}, ExpiresAt: NumericDate{now.Add(time.Hour)}}, keys.kid, keys.key)
+	if _, err := s.ValidateSession(token); err != ErrExpiredSession {
+		t.Errorf("expected ErrExpiredSession, got %v", err)
+	}
+}
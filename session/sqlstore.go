@@ -0,0 +1,326 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/utils"
+)
+
+// SQLStore is a SessionStore backed by the sessions table
+type SQLStore struct {
+	db utils.UTCDB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{
+		db: utils.UTCDB{DB: db},
+	}
+}
+
+func (s *SQLStore) Create(session *Session) error {
+	_, err := s.db.Exec("INSERT INTO sessions (id, user_id, expires_at, created_at, last_seen_at, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		session.Id, session.UserId, session.ExpiresAt, session.CreatedAt, session.LastSeenAt, session.IP, session.UserAgent)
+	if err != nil {
+		return fmt.Errorf("could not insert session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Get(id SessionID) (*Session, error) {
+	row := s.db.QueryRow("SELECT id, user_id, expires_at, created_at, last_seen_at, ip, user_agent FROM sessions WHERE id = $1", id)
+
+	var session Session
+	err := row.Scan(&session.Id, &session.UserId, &session.ExpiresAt, &session.CreatedAt, &session.LastSeenAt, &session.IP, &session.UserAgent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("could not query session: %w", err)
+	}
+	return &session, nil
+}
+
+func (s *SQLStore) Touch(id SessionID, lastSeenAt, expiresAt time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = $1, expires_at = $2 WHERE id = $3", lastSeenAt, expiresAt, id)
+	if err != nil {
+		return fmt.Errorf("could not update session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Delete(id SessionID) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("could not delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListByUser(userId string, now time.Time) ([]*Session, error) {
+	rows, err := s.db.Query("SELECT id, user_id, expires_at, created_at, last_seen_at, ip, user_agent FROM sessions WHERE user_id = $1 AND expires_at > $2 ORDER BY last_seen_at DESC", userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.Id, &session.UserId, &session.ExpiresAt, &session.CreatedAt, &session.LastSeenAt, &session.IP, &session.UserAgent)
+		if err != nil {
+			return nil, fmt.Errorf("could not read session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLStore) DeleteByUser(userId string, exceptId SessionID) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userId, exceptId)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete sessions: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted sessions: %w", err)
+	}
+	return int(removed), nil
+}
+
+// DeleteExpired removes a batch of expired sessions. Concurrent sweeps on
+// several replicas are safe: a row is only ever deleted once and the others skip it.
+func (s *SQLStore) DeleteExpired(before time.Time, limit int) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE id IN (SELECT id FROM sessions WHERE expires_at < $1 LIMIT $2)", before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired sessions: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted sessions: %w", err)
+	}
+	return int(removed), nil
+}
+
+func (s *SQLStore) Revoke(revocation Revocation) error {
+	_, err := s.db.Exec("INSERT INTO session_revocations (session_id, user_id, except_id, revoked_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		revocation.SessionId, revocation.UserId, revocation.ExceptId, revocation.RevokedAt, revocation.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not insert revocation: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Revoked(sessionId SessionID, userId string, issuedAt, now time.Time) (bool, error) {
+	row := s.db.QueryRow(`SELECT 1 FROM session_revocations
+		WHERE expires_at > $1 AND (session_id = $2 OR (user_id = $3 AND except_id <> $2 AND revoked_at >= $4))
+		LIMIT 1`, now, sessionId, userId, issuedAt)
+
+	var found int
+	err := row.Scan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not query revocations: %w", err)
+	}
+	return true, nil
+}
+
+func (s *SQLStore) DeleteExpiredRevocations(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM session_revocations WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired revocations: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted revocations: %w", err)
+	}
+	return int(removed), nil
+}
+
+func (s *SQLStore) CreateTokenFamily(family *TokenFamily, token *RefreshToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO token_families (id, user_id, session_id, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		family.Id, family.UserId, family.SessionId, family.CreatedAt, family.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not insert token family: %w", err)
+	}
+	if err := insertRefreshToken(tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetTokenFamily(id string) (*TokenFamily, error) {
+	row := s.db.QueryRow("SELECT id, user_id, session_id, created_at, expires_at, revoked_at FROM token_families WHERE id = $1", id)
+
+	var family TokenFamily
+	var revokedAt sql.NullTime
+	err := row.Scan(&family.Id, &family.UserId, &family.SessionId, &family.CreatedAt, &family.ExpiresAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("could not query token family: %w", err)
+	}
+	family.RevokedAt = revokedAt.Time
+	return &family, nil
+}
+
+func (s *SQLStore) GetRefreshToken(id string) (*RefreshToken, error) {
+	row := s.db.QueryRow("SELECT id, family_id, created_at, expires_at, used_at FROM refresh_tokens WHERE id = $1", id)
+
+	var token RefreshToken
+	var usedAt sql.NullTime
+	err := row.Scan(&token.Id, &token.FamilyId, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("could not query refresh token: %w", err)
+	}
+	token.UsedAt = usedAt.Time
+	return &token, nil
+}
+
+// UseRefreshToken relies on the conditional update to mark a token only once,
+// whichever replica gets there first
+func (s *SQLStore) UseRefreshToken(id string, usedAt time.Time) (*RefreshToken, bool, error) {
+	result, err := s.db.Exec("UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", usedAt, id)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not update refresh token: %w", err)
+	}
+	marked, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("could not count updated refresh tokens: %w", err)
+	}
+
+	row := s.db.QueryRow("SELECT id, family_id, created_at, expires_at, used_at FROM refresh_tokens WHERE id = $1", id)
+
+	var token RefreshToken
+	var tokenUsedAt sql.NullTime
+	err = row.Scan(&token.Id, &token.FamilyId, &token.CreatedAt, &token.ExpiresAt, &tokenUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, ErrRefreshTokenNotFound
+		}
+		return nil, false, fmt.Errorf("could not query refresh token: %w", err)
+	}
+	token.UsedAt = tokenUsedAt.Time
+	return &token, marked == 1, nil
+}
+
+func (s *SQLStore) RotateRefreshToken(familyId string, sessionId SessionID, token *RefreshToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertRefreshToken(tx, token); err != nil {
+		return err
+	}
+	// A revocation committed since the family was read wins
+	result, err := tx.Exec("UPDATE token_families SET session_id = $1, expires_at = $2 WHERE id = $3 AND revoked_at IS NULL",
+		sessionId, token.ExpiresAt, familyId)
+	if err != nil {
+		return fmt.Errorf("could not update token family: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not count updated token families: %w", err)
+	}
+	if updated == 0 {
+		return ErrRefreshTokenNotFound
+	}
+	return tx.Commit()
+}
+
+// RevokeTokenFamily locks the family with the update, a concurrent rotation either
+// committed before and its session is the one deleted, or fails on the revoked family
+func (s *SQLStore) RevokeTokenFamily(id string, revokedAt time.Time) (SessionID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sessionId SessionID
+	err = tx.QueryRow("UPDATE token_families SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2 RETURNING session_id",
+		revokedAt, id).Scan(&sessionId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrRefreshTokenNotFound
+		}
+		return "", fmt.Errorf("could not revoke token family: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE id = $1", sessionId); err != nil {
+		return "", fmt.Errorf("could not delete session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return sessionId, nil
+}
+
+func (s *SQLStore) RevokeTokenFamilies(revocation Revocation) (int, error) {
+	result, err := s.db.Exec(`UPDATE token_families SET revoked_at = $1
+		WHERE revoked_at IS NULL AND (session_id = $2 OR (user_id = $3 AND session_id <> $4))`,
+		revocation.RevokedAt, revocation.SessionId, revocation.UserId, revocation.ExceptId)
+	if err != nil {
+		return 0, fmt.Errorf("could not revoke token families: %w", err)
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count revoked token families: %w", err)
+	}
+	return int(revoked), nil
+}
+
+func (s *SQLStore) DeleteExpiredTokenFamilies(before time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A family outlives its tokens, remove them first
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE expires_at < $1 OR family_id IN (SELECT id FROM token_families WHERE expires_at < $1)", before)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired refresh tokens: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM token_families WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired token families: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not count deleted token families: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return int(removed), nil
+}
+
+func insertRefreshToken(tx utils.UTCTx, token *RefreshToken) error {
+	_, err := tx.Exec("INSERT INTO refresh_tokens (id, family_id, created_at, expires_at) VALUES ($1, $2, $3, $4)",
+		token.Id, token.FamilyId, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not insert refresh token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionStore when no session matches the id
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore persists sessions, keyed by their hashed id
+type SessionStore interface {
+	// Create saves a new session
+	Create(session *Session) error
+	// Get returns the session with the given id, or ErrSessionNotFound
+	Get(id SessionID) (*Session, error)
+	// Touch records activity on a session and sets its new expiration time
+	Touch(id SessionID, lastSeenAt, expiresAt time.Time) error
+	// Delete removes a session, deleting an unknown session is not an error
+	Delete(id SessionID) error
+	// ListByUser returns the sessions of a user that have not expired at the given time,
+	// most recently used first
+	ListByUser(userId string, now time.Time) ([]*Session, error)
+	// DeleteByUser removes every session of a user except the one with the given id,
+	// pass an empty id to remove them all. It returns how many were removed
+	DeleteByUser(userId string, exceptId SessionID) (int, error)
+	// DeleteExpired removes at most limit sessions that expired before the given
+	// time and returns how many were removed
+	DeleteExpired(before time.Time, limit int) (int, error)
+	// Revoke records a revocation of signed tokens
+	Revoke(revocation Revocation) error
+	// Revoked reports whether a revocation that has not expired at the given time
+	// covers the session, issued at issuedAt to the user
+	Revoked(sessionId SessionID, userId string, issuedAt, now time.Time) (bool, error)
+	// DeleteExpiredRevocations removes the revocations that expired before the given time
+	DeleteExpiredRevocations(before time.Time) (int, error)
+	// CreateTokenFamily saves a new token family along with its first refresh token
+	CreateTokenFamily(family *TokenFamily, token *RefreshToken) error
+	// GetTokenFamily returns the family with the given id, or ErrRefreshTokenNotFound
+	GetTokenFamily(id string) (*TokenFamily, error)
+	// GetRefreshToken returns the refresh token with the given id, or ErrRefreshTokenNotFound
+	GetRefreshToken(id string) (*RefreshToken, error)
+	// UseRefreshToken marks a refresh token used at the given time, unless it already was.
+	// It returns the token and whether this call marked it, or ErrRefreshTokenNotFound.
+	// Concurrent calls for the same token must mark it only once.
+	UseRefreshToken(id string, usedAt time.Time) (*RefreshToken, bool, error)
+	// RotateRefreshToken saves the next refresh token of a family and moves the family
+	// to the given session. It returns ErrRefreshTokenNotFound when the family is unknown
+	// or was revoked, also while rotating.
+	RotateRefreshToken(familyId string, sessionId SessionID, token *RefreshToken) error
+	// RevokeTokenFamily revokes a family at revokedAt and deletes its current session,
+	// atomically with rotations: the session deleted is the last one the family moved to.
+	// It returns the id of that session, or ErrRefreshTokenNotFound.
+	RevokeTokenFamily(id string, revokedAt time.Time) (SessionID, error)
+	// RevokeTokenFamilies revokes, at RevokedAt, the families of the sessions covered by
+	// the revocation: a single session, or every session of a user except one.
+	// It returns how many were revoked
+	RevokeTokenFamilies(revocation Revocation) (int, error)
+	// DeleteExpiredTokenFamilies removes the families and refresh tokens that expired
+	// before the given time, and returns how many families were removed
+	DeleteExpiredTokenFamilies(before time.Time) (int, error)
+}
+
+// Revocation denies signed tokens, which stay valid after their session is deleted,
+// until they expire on their own. It covers either a single session, or every
+// session of a user issued up to RevokedAt except one.
+type Revocation struct {
+	SessionId SessionID
+	UserId    string
+	ExceptId  SessionID
+	RevokedAt time.Time
+	// ExpiresAt is when every token covered has expired, and the revocation can be dropped
+	ExpiresAt time.Time
+}
+
+// covers reports whether the revocation applies to the session, issued at issuedAt to the user
+func (r Revocation) covers(sessionId SessionID, userId string, issuedAt, now time.Time) bool {
+	if !r.ExpiresAt.After(now) {
+		return false
+	}
+	if r.SessionId != "" {
+		return r.SessionId == sessionId
+	}
+	return r.UserId == userId && r.ExceptId != sessionId && !r.RevokedAt.Before(issuedAt)
+}
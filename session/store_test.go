@@ -0,0 +1,352 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aloysb/auth-session/internal/database/dbtest"
+)
+
+// storeFactories builds every SessionStore implementation on a fresh backend
+var storeFactories = map[string]func(t *testing.T) SessionStore{
+	"memory": func(t *testing.T) SessionStore {
+		return NewMemoryStore()
+	},
+	"sqlite": func(t *testing.T) SessionStore {
+		return NewSQLStore(dbtest.SQLite(t))
+	},
+}
+
+func TestStore_Lifecycle(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			now := time.Now().Truncate(time.Second)
+			session := &Session{
+				UserId:     "user123",
+				Id:         Token("token").SessionID(),
+				ExpiresAt:  now.Add(time.Hour),
+				CreatedAt:  now,
+				LastSeenAt: now,
+				IP:         "203.0.113.7",
+				UserAgent:  "Mozilla/5.0",
+			}
+			if err := store.Create(session); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			got, err := store.Get(session.Id)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got.UserId != session.UserId || !got.ExpiresAt.Equal(session.ExpiresAt) || !got.CreatedAt.Equal(session.CreatedAt) ||
+				got.IP != session.IP || got.UserAgent != session.UserAgent {
+				t.Errorf("expected %+v, got %+v", session, got)
+			}
+
+			lastSeenAt := session.LastSeenAt.Add(time.Minute)
+			expiresAt := session.ExpiresAt.Add(time.Hour)
+			if err := store.Touch(session.Id, lastSeenAt, expiresAt); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, _ = store.Get(session.Id)
+			if !got.ExpiresAt.Equal(expiresAt) || !got.LastSeenAt.Equal(lastSeenAt) {
+				t.Errorf("expected expiry %v and last seen %v, got %+v", expiresAt, lastSeenAt, got)
+			}
+
+			if err := store.Delete(session.Id); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := store.Get(session.Id); err != ErrSessionNotFound {
+				t.Errorf("expected ErrSessionNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_EvictsExpired(t *testing.T) {
+	store := NewMemoryStore()
+
+	expired := &Session{UserId: "user123", Id: "expired", ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.Create(expired); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Pretend the last sweep happened long ago, the next write evicts the expired session
+	store.lastEviction = time.Now().Add(-2 * memoryEvictionInterval)
+	valid := &Session{UserId: "user123", Id: "valid", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Create(valid); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := store.Get(expired.Id); err != ErrSessionNotFound {
+		t.Errorf("expected expired session to be evicted, got %v", err)
+	}
+	if _, err := store.Get(valid.Id); err != nil {
+		t.Errorf("expected valid session to be kept, got %v", err)
+	}
+}
+
+func TestStore_DeleteExpired(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			for i, expiresAt := range []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+				session := &Session{UserId: "user123", Id: Token(fmt.Sprint(i)).SessionID(), ExpiresAt: expiresAt}
+				if err := store.Create(session); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			removed, err := store.DeleteExpired(time.Now(), 1)
+			if err != nil || removed != 1 {
+				t.Fatalf("expected 1 session removed, got %d (%v)", removed, err)
+			}
+			removed, err = store.DeleteExpired(time.Now(), 10)
+			if err != nil || removed != 1 {
+				t.Fatalf("expected 1 session removed, got %d (%v)", removed, err)
+			}
+			removed, err = store.DeleteExpired(time.Now(), 10)
+			if err != nil || removed != 0 {
+				t.Fatalf("expected nothing left to remove, got %d (%v)", removed, err)
+			}
+		})
+	}
+}
+
+func TestStore_ListAndDeleteByUser(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			now := time.Now()
+
+			sessions := []*Session{
+				{UserId: "user123", Id: "old", ExpiresAt: now.Add(time.Hour), LastSeenAt: now.Add(-time.Hour)},
+				{UserId: "user123", Id: "recent", ExpiresAt: now.Add(time.Hour), LastSeenAt: now},
+				{UserId: "user123", Id: "expired", ExpiresAt: now.Add(-time.Hour), LastSeenAt: now.Add(-2 * time.Hour)},
+				{UserId: "someoneElse", Id: "other", ExpiresAt: now.Add(time.Hour), LastSeenAt: now},
+			}
+			for _, session := range sessions {
+				session.CreatedAt = session.LastSeenAt
+				if err := store.Create(session); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			listed, err := store.ListByUser("user123", now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(listed) != 2 || listed[0].Id != "recent" || listed[1].Id != "old" {
+				t.Errorf("expected active sessions most recent first, got %+v", listed)
+			}
+
+			removed, err := store.DeleteByUser("user123", "recent")
+			if err != nil || removed != 2 {
+				t.Fatalf("expected 2 sessions removed, got %d (%v)", removed, err)
+			}
+			if _, err := store.Get("recent"); err != nil {
+				t.Errorf("expected the kept session to remain, got %v", err)
+			}
+			if _, err := store.Get("other"); err != nil {
+				t.Errorf("expected other users' sessions to remain, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_Revocations(t *testing.T) {
+	for name, factory := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := factory(t)
+			now := time.Now()
+			issued := now.Add(-time.Minute)
+
+			revocations := []Revocation{
+				{SessionId: "revoked", RevokedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{UserId: "user123", ExceptId: "kept", RevokedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{SessionId: "expired", RevokedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+			}
+			for _, revocation := range revocations {
+				if err := store.Revoke(revocation); err != nil {
+					t.Fatalf("failed to revoke: %v", err)
+				}
+			}
+
+			tests := []struct {
+				name      string
+				sessionId SessionID
+				userId    string
+				issuedAt  time.Time
+				want      bool
+			}{
+				{name: "revoked session", sessionId: "revoked", userId: "user456", issuedAt: issued, want: true},
+				{name: "user session", sessionId: "other", userId: "user123", issuedAt: issued, want: true},
+				{name: "kept session", sessionId: "kept", userId: "user123", issuedAt: issued, want: false},
+				{name: "issued after", sessionId: "new", userId: "user123", issuedAt: now.Add(time.Second), want: false},
+				{name: "expired revocation", sessionId: "expired", userId: "user456", issuedAt: issued, want: false},
+				{name: "unrelated", sessionId: "unrelated", userId: "user456", issuedAt: issued, want: false},
+			}
+			for _, tt := range tests {
+				got, err := store.Revoked(tt.sessionId, tt.userId, tt.issuedAt, now)
+				if err != nil {
+					t.Fatalf("%s: expected no error, got %v", tt.name, err)
+				}
+				if got != tt.want {
+					t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				}
+			}
+
+			removed, err := store.DeleteExpiredRevocations(now)
+			if err != nil || removed != 1 {
+				t.Errorf("DeleteExpiredRevocations: got (%d, %v), want (1, nil)", removed, err)
+			}
+			if revoked, _ := store.Revoked("revoked", "", issued, now); !revoked {
+				t.Errorf("expected the live revocation to be kept")
+			}
+		})
+	}
+}
+
+func TestStore_TokenFamilies(t *testing.T) {
+	for name, factory := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := factory(t)
+			now := time.Now()
+
+			family := &TokenFamily{Id: "family", UserId: "user123", SessionId: "first", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			first := &RefreshToken{Id: "first-token", FamilyId: "family", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			if err := store.CreateTokenFamily(family, first); err != nil {
+				t.Fatalf("failed to create token family: %v", err)
+			}
+
+			token, marked, err := store.UseRefreshToken("first-token", now)
+			if err != nil || !marked || token.FamilyId != "family" {
+				t.Fatalf("UseRefreshToken: got (%+v, %v, %v), want the token marked", token, marked, err)
+			}
+			if _, marked, err := store.UseRefreshToken("first-token", now); err != nil || marked {
+				t.Errorf("expected a used token not to be marked again, got (%v, %v)", marked, err)
+			}
+			if _, _, err := store.UseRefreshToken("unknown", now); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected ErrRefreshTokenNotFound, got %v", err)
+			}
+
+			next := &RefreshToken{Id: "next-token", FamilyId: "family", CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour)}
+			if err := store.RotateRefreshToken("family", "second", next); err != nil {
+				t.Fatalf("failed to rotate refresh token: %v", err)
+			}
+			got, err := store.GetTokenFamily("family")
+			if err != nil {
+				t.Fatalf("failed to get token family: %v", err)
+			}
+			if got.SessionId != "second" || !got.ExpiresAt.Equal(next.ExpiresAt) || !got.RevokedAt.IsZero() {
+				t.Errorf("expected the family to move to the new session, got %+v", got)
+			}
+			if err := store.RotateRefreshToken("unknown", "second", &RefreshToken{Id: "other-token", FamilyId: "unknown", CreatedAt: now, ExpiresAt: now}); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected ErrRefreshTokenNotFound for an unknown family, got %v", err)
+			}
+
+			// Revoking the replaced session leaves the family alone
+			if revoked, err := store.RevokeTokenFamilies(Revocation{SessionId: "first", RevokedAt: now}); err != nil || revoked != 0 {
+				t.Errorf("RevokeTokenFamilies: got (%d, %v), want (0, nil)", revoked, err)
+			}
+			if revoked, err := store.RevokeTokenFamilies(Revocation{UserId: "user123", ExceptId: "second", RevokedAt: now}); err != nil || revoked != 0 {
+				t.Errorf("RevokeTokenFamilies: got (%d, %v), want (0, nil)", revoked, err)
+			}
+			if revoked, err := store.RevokeTokenFamilies(Revocation{UserId: "user123", RevokedAt: now}); err != nil || revoked != 1 {
+				t.Errorf("RevokeTokenFamilies: got (%d, %v), want (1, nil)", revoked, err)
+			}
+			if got, _ := store.GetTokenFamily("family"); got.RevokedAt.IsZero() {
+				t.Errorf("expected the family to be revoked")
+			}
+
+			if removed, err := store.DeleteExpiredTokenFamilies(now.Add(90 * time.Minute)); err != nil || removed != 0 {
+				t.Errorf("DeleteExpiredTokenFamilies: got (%d, %v), want (0, nil)", removed, err)
+			}
+			if _, _, err := store.UseRefreshToken("first-token", now); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected the expired token to be removed, got %v", err)
+			}
+			if removed, err := store.DeleteExpiredTokenFamilies(now.Add(3 * time.Hour)); err != nil || removed != 1 {
+				t.Errorf("DeleteExpiredTokenFamilies: got (%d, %v), want (1, nil)", removed, err)
+			}
+			if _, err := store.GetTokenFamily("family"); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected ErrRefreshTokenNotFound, got %v", err)
+			}
+			if _, _, err := store.UseRefreshToken("next-token", now); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected the family's tokens to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_RevokeTokenFamily(t *testing.T) {
+	for name, factory := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			store := factory(t)
+			now := time.Now()
+
+			store.Create(&Session{Id: "current", UserId: "user123", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+			family := &TokenFamily{Id: "family", UserId: "user123", SessionId: "current", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			first := &RefreshToken{Id: "first-token", FamilyId: "family", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			if err := store.CreateTokenFamily(family, first); err != nil {
+				t.Fatalf("failed to create token family: %v", err)
+			}
+
+			sessionId, err := store.RevokeTokenFamily("family", now)
+			if err != nil || sessionId != "current" {
+				t.Fatalf("RevokeTokenFamily: got (%q, %v), want (current, nil)", sessionId, err)
+			}
+			if _, err := store.Get("current"); err != ErrSessionNotFound {
+				t.Errorf("expected the current session to be deleted, got %v", err)
+			}
+			if got, _ := store.GetTokenFamily("family"); got.RevokedAt.IsZero() {
+				t.Errorf("expected the family to be revoked")
+			}
+
+			// A rotation racing with the revocation loses
+			next := &RefreshToken{Id: "next-token", FamilyId: "family", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			if err := store.RotateRefreshToken("family", "next", next); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected ErrRefreshTokenNotFound for a revoked family, got %v", err)
+			}
+			if got, _ := store.GetTokenFamily("family"); got.SessionId != "current" {
+				t.Errorf("expected the revoked family to stay on its session, got %s", got.SessionId)
+			}
+
+			if _, err := store.RevokeTokenFamily("unknown", now); err != ErrRefreshTokenNotFound {
+				t.Errorf("expected ErrRefreshTokenNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStore_BindsUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	db := dbtest.SQLite(t)
+	store := NewSQLStore(db)
+	now := time.Now()
+	session := &Session{UserId: "user123", Id: "session", ExpiresAt: now.Add(time.Hour), CreatedAt: now, LastSeenAt: now}
+	if err := store.Create(session); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := store.Revoke(Revocation{SessionId: "other", RevokedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// SQLite compares the timestamps as text, every table has them in UTC
+	for _, query := range []string{"SELECT expires_at FROM sessions", "SELECT expires_at FROM session_revocations"} {
+		var expiresAt string
+		if err := db.QueryRow(query).Scan(&expiresAt); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !strings.HasSuffix(expiresAt, "+00:00") && !strings.HasSuffix(expiresAt, "Z") {
+			t.Errorf("%s: expected a UTC timestamp, got %s", query, expiresAt)
+		}
+	}
+}
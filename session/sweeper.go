@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultSweepInterval  = 5 * time.Minute
+	DefaultSweepBatchSize = 1000
+)
+
+// Sweeper periodically purges expired sessions from a store, so abandoned
+// sessions do not accumulate. Several replicas may sweep the same store.
+type Sweeper struct {
+	store     SessionStore
+	interval  time.Duration
+	batchSize int
+	removed   atomic.Int64
+}
+
+func NewSweeper(store SessionStore, interval time.Duration, batchSize int) *Sweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultSweepBatchSize
+	}
+	return &Sweeper{
+		store:     store,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run sweeps on every interval until the context is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := s.Sweep(ctx)
+			if err != nil {
+				slog.Error("Session sweep failed", "error", err, "removed", removed)
+				continue
+			}
+			if removed > 0 {
+				slog.Info("Swept expired sessions", "removed", removed)
+			}
+		}
+	}
+}
+
+// Sweep deletes expired sessions batch by batch, until a batch comes back
+// short, and returns how many sessions were removed.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	now := time.Now()
+	total := 0
+
+	for ctx.Err() == nil {
+		removed, err := s.store.DeleteExpired(now, s.batchSize)
+		total += removed
+		s.removed.Add(int64(removed))
+		if err != nil {
+			return total, fmt.Errorf("could not sweep sessions: %w", err)
+		}
+		if removed < s.batchSize {
+			break
+		}
+	}
+
+	// Revocations of signed tokens are only kept until the tokens expire
+	if _, err := s.store.DeleteExpiredRevocations(now); err != nil {
+		return total, fmt.Errorf("could not sweep revocations: %w", err)
+	}
+	if _, err := s.store.DeleteExpiredTokenFamilies(now); err != nil {
+		return total, fmt.Errorf("could not sweep refresh tokens: %w", err)
+	}
+
+	return total, nil
+}
+
+// Removed returns the number of sessions removed since the sweeper was created
+func (s *Sweeper) Removed() int64 {
+	return s.removed.Load()
+}
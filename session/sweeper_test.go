@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSweeper_Sweep(t *testing.T) {
+	store := NewMemoryStore()
+
+	for i := 0; i < 5; i++ {
+		expired := &Session{UserId: "user123", Id: SessionID(fmt.Sprintf("expired-%d", i)), ExpiresAt: time.Now().Add(-time.Hour)}
+		if err := store.Create(expired); err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+	valid := &Session{UserId: "user123", Id: "valid", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Create(valid); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// A batch size smaller than the backlog forces several batches
+	sweeper := NewSweeper(store, time.Minute, 2)
+	removed, err := sweeper.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if removed != 5 {
+		t.Errorf("expected 5 sessions removed, got %d", removed)
+	}
+	if sweeper.Removed() != 5 {
+		t.Errorf("expected removed counter to be 5, got %d", sweeper.Removed())
+	}
+	if _, err := store.Get(valid.Id); err != nil {
+		t.Errorf("expected valid session to be kept, got %v", err)
+	}
+}
+
+func TestSweeper_RunStopsWithContext(t *testing.T) {
+	store := NewMemoryStore()
+	expired := &Session{UserId: "user123", Id: "expired", ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.Create(expired); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	sweeper := NewSweeper(store, 10*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for sweeper.Removed() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sweeper.Removed() != 1 {
+		t.Errorf("expected the background sweep to remove 1 session, got %d", sweeper.Removed())
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected sweeper to stop when the context is cancelled")
+	}
+}